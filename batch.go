@@ -0,0 +1,154 @@
+package retrigo
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// DefaultBatchConcurrency is the default number of requests DoAll and
+	// DoStream run at the same time
+	DefaultBatchConcurrency = 10
+)
+
+// BatchOptions configures DoAll and DoStream
+type BatchOptions struct {
+	Concurrency int  // Requests run at the same time, DefaultBatchConcurrency when zero
+	FailFast    bool // Cancel the remaining requests once one fails
+}
+
+// Result is the outcome of one request of a batch
+type Result struct {
+	Index    int            // Position of the request in the batch
+	Request  *Request       // The request
+	Response *http.Response // The response, its body must be closed by the caller
+	Err      error          // The error returned by Do
+	Attempts int            // Number of attempts made
+	Target   string         // Url of the last attempt
+}
+
+// cancelBody cancels the request context once the body is closed, so the
+// context of a successful request lives as long as its response.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// DoAll runs reqs through Do, at most opts.Concurrency at a time, and returns
+// their results in the order of reqs. ctx replaces the context of every
+// request. With opts.FailFast the first error cancels the requests still
+// running and the ones not started yet fail with the context error.
+func (c *Client) DoAll(ctx context.Context, reqs []*Request, opts *BatchOptions) []Result {
+	results := make([]Result, len(reqs))
+	for r := range c.DoStream(ctx, reqs, opts) {
+		results[r.Index] = r
+	}
+	return results
+}
+
+// DoStream is like DoAll but sends each result on the returned channel as
+// soon as its request completes. The channel is closed once every request
+// completed.
+func (c *Client) DoStream(ctx context.Context, reqs []*Request, opts *BatchOptions) <-chan Result {
+	if opts == nil {
+		opts = &BatchOptions{}
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	if concurrency > len(reqs) {
+		concurrency = len(reqs)
+	}
+
+	// stopped is closed on the first failure when failing fast, it cancels
+	// the requests still running but not the ones already done, whose
+	// bodies may still be read.
+	stopped := make(chan struct{})
+	var once sync.Once
+	results := make(chan Result)
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				r := c.doBatched(ctx, stopped, i, reqs[i])
+				if r.Err != nil && opts.FailFast {
+					once.Do(func() { close(stopped) })
+				}
+				results <- r
+			}
+		}()
+	}
+
+	go func() {
+		for i := range reqs {
+			indexes <- i
+		}
+		close(indexes)
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+func (c *Client) doBatched(ctx context.Context, stopped <-chan struct{}, i int, req *Request) Result {
+	result := Result{Index: i, Request: req}
+	result.Response, result.Err = c.doUntil(ctx, stopped, req)
+	result.Attempts = req.Attempts()
+	if result.Attempts > 0 && req.URL != nil {
+		result.Target = req.URL.String()
+	}
+	return result
+}
+
+// doUntil runs req through Do with ctx, cancelling it if stopped is closed
+// before Do returns. A response returned in time keeps its context alive
+// until its body is closed.
+func (c *Client) doUntil(ctx context.Context, stopped <-chan struct{}, req *Request) (*http.Response, error) {
+	select {
+	case <-stopped:
+		return nil, context.Canceled
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// state goes from 0 to 1 when the request is cancelled, or to 2 once Do
+	// returned, whichever comes first
+	var state int32
+	reqCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-stopped:
+			if atomic.CompareAndSwapInt32(&state, 0, 1) {
+				cancel()
+			}
+		case <-done:
+		}
+	}()
+	resp, err := c.Do(req.WithContext(reqCtx))
+	atomic.CompareAndSwapInt32(&state, 0, 2)
+	close(done)
+	if err != nil || resp == nil {
+		cancel()
+		return resp, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
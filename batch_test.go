@@ -0,0 +1,196 @@
+package retrigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_DoAll(t *testing.T) {
+	var inflight, peak int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	reqs := make([]*Request, 20)
+	for i := range reqs {
+		var err error
+		reqs[i], err = NewRequest("GET", fmt.Sprintf("http://127.0.0.1:1/%d %s/%d", i, ts.URL, i), nil)
+		checkErr(t, err, true)
+	}
+
+	client := NewClient()
+	results := client.DoAll(context.Background(), reqs, &BatchOptions{Concurrency: 4})
+	if len(results) != len(reqs) {
+		t.Fatalf("bad results: %d", len(results))
+	}
+	for i, r := range results {
+		checkErr(t, r.Err, true)
+		body, err := io.ReadAll(r.Response.Body)
+		checkErr(t, err, true)
+		r.Response.Body.Close()
+		if r.Index != i || r.Request != reqs[i] || string(body) != fmt.Sprintf("/%d", i) {
+			t.Fatalf("result %d out of order: %d %s", i, r.Index, body)
+		}
+		if r.Attempts != 2 || r.Target != fmt.Sprintf("%s/%d", ts.URL, i) {
+			t.Fatalf("bad attempt info: %d %s", r.Attempts, r.Target)
+		}
+	}
+	if p := atomic.LoadInt32(&peak); p > 4 {
+		t.Fatalf("concurrency limit exceeded: %d", p)
+	}
+}
+
+func TestClient_DoAll_failFast(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(500)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.RetryMax = 1
+	reqs := make([]*Request, 10)
+	for i := range reqs {
+		path := "/slow"
+		if i == 1 {
+			path = "/fail"
+		}
+		var err error
+		reqs[i], err = NewRequest("GET", ts.URL+path, nil)
+		checkErr(t, err, true)
+	}
+
+	start := time.Now()
+	results := client.DoAll(context.Background(), reqs, &BatchOptions{Concurrency: 2, FailFast: true})
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("remaining requests were not cancelled")
+	}
+	if !errors.Is(results[1].Err, ErrGiveUp) {
+		t.Fatalf("expected give up, got: %v", results[1].Err)
+	}
+	for _, r := range results[2:] {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Fatalf("request %d: expected canceled, got: %v", r.Index, r.Err)
+		}
+		if r.Index > 2 && r.Attempts != 0 {
+			t.Fatalf("request %d should not have started", r.Index)
+		}
+	}
+}
+
+func TestClient_DoAll_backoff(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(400)
+			return
+		}
+		w.WriteHeader(503)
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.RetryWaitMin = 3 * time.Second
+	client.RetryWaitMax = 3 * time.Second
+	newReqs := func(fail int) []*Request {
+		reqs := make([]*Request, 4)
+		for i := range reqs {
+			path := "/unavailable"
+			if i == fail {
+				path = "/fail"
+			}
+			var err error
+			reqs[i], err = NewRequest("GET", ts.URL+path, nil)
+			checkErr(t, err, true)
+		}
+		return reqs
+	}
+
+	// Requests waiting out a backoff give up with the batch context
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	results := client.DoAll(ctx, newReqs(-1), &BatchOptions{Concurrency: 4})
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("context not honoured during backoff: %s", d)
+	}
+	for _, r := range results {
+		if !errors.Is(r.Err, context.DeadlineExceeded) {
+			t.Fatalf("request %d: expected deadline exceeded, got: %v", r.Index, r.Err)
+		}
+	}
+
+	// and with the first failure of a FailFast batch
+	client.CheckForRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if resp != nil && resp.StatusCode == 400 {
+			return false, errors.New("bad request")
+		}
+		return DefaultRetryPolicy(ctx, resp, err)
+	}
+	reqs := newReqs(3)
+	start = time.Now()
+	results = client.DoAll(context.Background(), reqs, &BatchOptions{Concurrency: 4, FailFast: true})
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("remaining requests not cancelled during backoff: %s", d)
+	}
+	for _, r := range results[:3] {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Fatalf("request %d: expected canceled, got: %v", r.Index, r.Err)
+		}
+	}
+}
+
+func TestClient_DoStream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	var reqs []*Request
+	for _, path := range []string{"/slow", "/fast"} {
+		req, err := NewRequest("GET", ts.URL+path, nil)
+		checkErr(t, err, true)
+		reqs = append(reqs, req)
+	}
+
+	var order []int
+	for r := range NewClient().DoStream(context.Background(), reqs, nil) {
+		checkErr(t, r.Err, true)
+		// The body outlives the batch
+		body, err := io.ReadAll(r.Response.Body)
+		checkErr(t, err, true)
+		r.Response.Body.Close()
+		if string(body) != []string{"/slow", "/fast"}[r.Index] {
+			t.Fatalf("bad body: %s", body)
+		}
+		order = append(order, r.Index)
+	}
+	if len(order) != 2 || order[0] != 1 {
+		t.Fatalf("expected completion order, got: %v", order)
+	}
+}
@@ -0,0 +1,123 @@
+package retrigo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	// ErrBroadcastTimeout is the error of the targets which didn't complete
+	// before BroadcastOptions.Timeout
+	ErrBroadcastTimeout = errors.New("no result before the broadcast timeout")
+
+	// ErrPartial is returned by Broadcast, wrapped, when some targets failed
+	// and BroadcastOptions.RequireAll is set
+	ErrPartial = errors.New("some targets failed")
+)
+
+// Aggregator combines the results of a broadcast, one per target in the order
+// of the targets, into a single value. Targets which failed have Err set.
+type Aggregator func(results []TargetResult) (interface{}, error)
+
+// BroadcastOptions configures Broadcast
+type BroadcastOptions struct {
+	// Timeout, when set, bounds the whole broadcast: the targets still
+	// running are cancelled and aggregated with ErrBroadcastTimeout.
+	Timeout time.Duration
+
+	// RequireAll makes Broadcast fail with ErrPartial if any target failed,
+	// instead of leaving it to the aggregator
+	RequireAll bool
+}
+
+// CollectBodies is an Aggregator returning the body of every response by
+// target url, as a map[string][]byte. Failed targets are left out.
+func CollectBodies(results []TargetResult) (interface{}, error) {
+	bodies := make(map[string][]byte, len(results))
+	for _, r := range results {
+		if r.Err != nil {
+			continue
+		}
+		body, err := io.ReadAll(r.Response.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", r.Target, err)
+		}
+		bodies[r.Target.String()] = body
+	}
+	return bodies, nil
+}
+
+// Broadcast sends req to every one of its targets concurrently, each with its
+// own retries following the client policy, and hands the results to agg once
+// every target completed or the timeout expired. Response bodies are closed
+// when agg returns, so it must read whatever it needs from them.
+func (c *Client) Broadcast(req *Request, agg Aggregator, opts *BroadcastOptions) (interface{}, error) {
+	if opts == nil {
+		opts = &BroadcastOptions{}
+	}
+
+	targets, reqs, err := c.split(req)
+	if err != nil {
+		return nil, err
+	}
+
+	type done struct {
+		i int
+		TargetResult
+	}
+	results := make(chan done, len(reqs))
+	stopped := make(chan struct{})
+	for i := range reqs {
+		go func(i int) {
+			r := done{i: i, TargetResult: TargetResult{Target: targets[i]}}
+			r.Response, r.Err = c.doUntil(req.Context(), stopped, reqs[i])
+			r.Attempts = reqs[i].Attempts()
+			r.OK = r.Err == nil
+			results <- r
+		}(i)
+	}
+
+	var timeout <-chan time.Time
+	if opts.Timeout > 0 {
+		timer := time.NewTimer(opts.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	all := make([]TargetResult, len(reqs))
+	expired := false
+	for n := 0; n < len(reqs); {
+		select {
+		case r := <-results:
+			if expired && r.Err != nil {
+				r.Err = ErrBroadcastTimeout
+			}
+			all[r.i] = r.TargetResult
+			n++
+		case <-timeout:
+			expired = true
+			timeout = nil
+			close(stopped)
+		}
+	}
+	defer func() {
+		for _, r := range all {
+			if r.Response != nil {
+				c.drainBody(r.Response.Body)
+			}
+		}
+	}()
+
+	failed := 0
+	for _, r := range all {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 && opts.RequireAll {
+		return nil, fmt.Errorf("%s %s: %w, %d of %d targets", req.Method, req.URL, ErrPartial, failed, len(all))
+	}
+	return agg(all)
+}
@@ -0,0 +1,130 @@
+package retrigo
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_Broadcast(t *testing.T) {
+	var flaky int32
+	servers := []*httptest.Server{
+		httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("a"))
+		})),
+		httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&flaky, 1) == 1 {
+				w.WriteHeader(503)
+				return
+			}
+			w.Write([]byte("b"))
+		})),
+		httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(500)
+		})),
+	}
+	urls := make([]string, len(servers))
+	for i, s := range servers {
+		defer s.Close()
+		urls[i] = s.URL + "/stats"
+	}
+
+	client := NewClient()
+	client.RetryMax = 2
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	req, err := NewRequest("GET", strings.Join(urls, " "), nil)
+	checkErr(t, err, true)
+
+	v, err := client.Broadcast(req, CollectBodies, nil)
+	checkErr(t, err, true)
+	bodies := v.(map[string][]byte)
+	if len(bodies) != 2 || string(bodies[servers[0].URL+"/stats"]) != "a" || string(bodies[servers[1].URL+"/stats"]) != "b" {
+		t.Fatalf("bad bodies: %q", bodies)
+	}
+
+	var got []TargetResult
+	_, err = client.Broadcast(req, func(results []TargetResult) (interface{}, error) {
+		got = results
+		return nil, nil
+	}, nil)
+	checkErr(t, err, true)
+	if len(got) != 3 || got[0].Target != req.Targets()[0] || !errors.Is(got[2].Err, ErrGiveUp) || got[2].Attempts != 3 {
+		t.Fatalf("bad results: %+v", got)
+	}
+
+	_, err = client.Broadcast(req, CollectBodies, &BroadcastOptions{RequireAll: true})
+	if !errors.Is(err, ErrPartial) {
+		t.Fatalf("expected ErrPartial, got %v", err)
+	}
+}
+
+func TestClient_Broadcast_timeout(t *testing.T) {
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	client := NewClient()
+	req, err := NewRequest("GET", fast.URL+" "+slow.URL, nil)
+	checkErr(t, err, true)
+
+	start := time.Now()
+	var got []TargetResult
+	v, err := client.Broadcast(req, func(results []TargetResult) (interface{}, error) {
+		got = results
+		return CollectBodies(results)
+	}, &BroadcastOptions{Timeout: 50 * time.Millisecond})
+	checkErr(t, err, true)
+	if time.Since(start) > 2*time.Second {
+		t.Fatal("timeout not honoured")
+	}
+	if got[1].Err != ErrBroadcastTimeout || got[0].Err != nil {
+		t.Fatalf("bad results: %+v", got)
+	}
+	if bodies := v.(map[string][]byte); len(bodies) != 1 || string(bodies[fast.URL]) != "fast" {
+		t.Fatalf("bad bodies: %q", bodies)
+	}
+}
+
+func TestClient_Broadcast_timeoutBackoff(t *testing.T) {
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer unavailable.Close()
+
+	client := NewClient()
+	client.RetryWaitMin = 3 * time.Second
+	client.RetryWaitMax = 3 * time.Second
+	req, err := NewRequest("GET", fast.URL+" "+unavailable.URL, nil)
+	checkErr(t, err, true)
+
+	start := time.Now()
+	var got []TargetResult
+	_, err = client.Broadcast(req, func(results []TargetResult) (interface{}, error) {
+		got = results
+		return CollectBodies(results)
+	}, &BroadcastOptions{Timeout: 100 * time.Millisecond})
+	checkErr(t, err, true)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("timeout not honoured during backoff: %s", d)
+	}
+	if got[1].Err != ErrBroadcastTimeout || got[0].Err != nil {
+		t.Fatalf("bad results: %+v", got)
+	}
+}
@@ -0,0 +1,298 @@
+package retrigo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+var (
+	// ErrNoInteraction is returned by a replaying CassetteTransport when no
+	// recorded interaction is left matching the request
+	ErrNoInteraction = errors.New("no matching interaction in cassette")
+
+	// DefaultMatchers are the request matchers used when replaying, unless
+	// CassetteTransport.Matchers is set
+	DefaultMatchers = []Matcher{MatchMethod, MatchURL}
+
+	// DefaultRedactedHeaders are the headers recorded as Redacted, unless
+	// CassetteTransport.RedactHeaders is set
+	DefaultRedactedHeaders = []string{"Authorization"}
+)
+
+// Redacted replaces the values of redacted headers in cassettes
+const Redacted = "REDACTED"
+
+// Interaction is a single recorded attempt: the request and either the
+// response or the transport error it got. Permanent records whether
+// IsRetryableError ruled out retrying the error, so it replays the same way.
+type Interaction struct {
+	Request   RecordedRequest   `json:"request"`
+	Response  *RecordedResponse `json:"response,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Permanent bool              `json:"permanent,omitempty"`
+}
+
+// RecordedRequest is the recorded form of a request
+type RecordedRequest struct {
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	Header   http.Header `json:"header,omitempty"`
+	Body     []byte      `json:"body,omitempty"`
+	BodyHash string      `json:"body_hash"`
+}
+
+// RecordedResponse is the recorded form of a response
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+}
+
+// Matcher reports whether a recorded request matches r, whose body is body
+type Matcher func(r *http.Request, body []byte, rec *RecordedRequest) bool
+
+// MatchMethod matches requests by method
+func MatchMethod(r *http.Request, body []byte, rec *RecordedRequest) bool {
+	return r.Method == rec.Method
+}
+
+// MatchURL matches requests by url
+func MatchURL(r *http.Request, body []byte, rec *RecordedRequest) bool {
+	return r.URL.String() == rec.URL
+}
+
+// MatchBody matches requests by the hash of their body
+func MatchBody(r *http.Request, body []byte, rec *RecordedRequest) bool {
+	return bodyHash(body) == rec.BodyHash
+}
+
+// MatchHeaders returns a Matcher matching requests by the values of the given
+// headers
+func MatchHeaders(names ...string) Matcher {
+	return func(r *http.Request, body []byte, rec *RecordedRequest) bool {
+		for _, name := range names {
+			if r.Header.Get(name) != rec.Header.Get(name) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func bodyHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// CassetteMode selects whether a CassetteTransport records or replays
+type CassetteMode int
+
+const (
+	// ModeRecord sends requests and records every attempt
+	ModeRecord CassetteMode = iota
+	// ModeReplay serves recorded responses without sending anything
+	ModeReplay
+)
+
+// CassetteTransport is an http.RoundTripper which records the attempts made
+// through it to a cassette file, or replays them from it. Wrapping the
+// Client.HTTPClient transport records every attempt of a retry sequence, so a
+// 503, 503, 200 sequence replays exactly as it was recorded.
+//
+// When replaying, each request is served the first interaction, in recording
+// order, that wasn't served yet and satisfies all Matchers. Redacted headers
+// are recorded as Redacted, so they can't be matched by MatchHeaders.
+type CassetteTransport struct {
+	Path          string            // Cassette file
+	Mode          CassetteMode      // Record or replay
+	Transport     http.RoundTripper // Transport requests are recorded from, http.DefaultTransport when nil
+	Matchers      []Matcher         // Request matchers used when replaying, DefaultMatchers when nil
+	RedactHeaders []string          // Request and response headers not recorded, DefaultRedactedHeaders when nil
+	Interactions  []*Interaction    // Recorded interactions
+
+	mu     sync.Mutex
+	served []bool
+}
+
+// NewRecorder creates a CassetteTransport recording the attempts sent through
+// rt to the file at path
+func NewRecorder(path string, rt http.RoundTripper) *CassetteTransport {
+	return &CassetteTransport{
+		Path:      path,
+		Mode:      ModeRecord,
+		Transport: rt,
+	}
+}
+
+// NewReplayer creates a CassetteTransport replaying the cassette at path
+func NewReplayer(path string) (*CassetteTransport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &CassetteTransport{Path: path, Mode: ModeReplay}
+	if err := json.Unmarshal(data, &c.Interactions); err != nil {
+		return nil, fmt.Errorf("reading cassette %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// RoundTrip records or replays a single attempt
+func (c *CassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Mode == ModeReplay {
+		return c.replay(req, body)
+	}
+	return c.record(req, body)
+}
+
+func (c *CassetteTransport) record(req *http.Request, body []byte) (*http.Response, error) {
+	rt := c.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	out := req.Clone(req.Context())
+	if req.Body != nil {
+		out.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	interaction := &Interaction{
+		Request: RecordedRequest{
+			Method:   req.Method,
+			URL:      req.URL.String(),
+			Header:   c.redact(req.Header),
+			Body:     body,
+			BodyHash: bodyHash(body),
+		},
+	}
+
+	resp, err := rt.RoundTrip(out)
+	if err != nil {
+		interaction.Error = err.Error()
+		interaction.Permanent = !IsRetryableError(err)
+	} else {
+		respBody, rerr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if rerr != nil {
+			return nil, rerr
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(respBody))
+		interaction.Response = &RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     c.redact(resp.Header),
+			Body:       respBody,
+		}
+	}
+
+	if serr := c.append(interaction); serr != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, serr
+	}
+	return resp, err
+}
+
+// redact returns a copy of header with the values of the redacted headers
+// replaced by Redacted
+func (c *CassetteTransport) redact(header http.Header) http.Header {
+	names := c.RedactHeaders
+	if names == nil {
+		names = DefaultRedactedHeaders
+	}
+	out := header.Clone()
+	for _, name := range names {
+		if out.Get(name) != "" {
+			out.Set(name, Redacted)
+		}
+	}
+	return out
+}
+
+// append adds an interaction and writes the whole cassette, so it's complete
+// even if the test never gets to clean up
+func (c *CassetteTransport) append(interaction *Interaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Interactions = append(c.Interactions, interaction)
+	data, err := json.MarshalIndent(c.Interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.Path, data, 0o644)
+}
+
+func (c *CassetteTransport) replay(req *http.Request, body []byte) (*http.Response, error) {
+	matchers := c.Matchers
+	if matchers == nil {
+		matchers = DefaultMatchers
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.served) < len(c.Interactions) {
+		c.served = append(c.served, make([]bool, len(c.Interactions)-len(c.served))...)
+	}
+
+next:
+	for i, interaction := range c.Interactions {
+		if c.served[i] {
+			continue
+		}
+		for _, match := range matchers {
+			if !match(req, body, &interaction.Request) {
+				continue next
+			}
+		}
+		c.served[i] = true
+
+		if interaction.Response == nil {
+			return nil, replayError{errors.New(interaction.Error), interaction.Permanent}
+		}
+		rec := interaction.Response
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", rec.StatusCode, http.StatusText(rec.StatusCode)),
+			StatusCode:    rec.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        rec.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(rec.Body)),
+			ContentLength: int64(len(rec.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	// Permanent since a replaying cassette won't grow new interactions
+	return nil, replayError{fmt.Errorf("%s %s: %w", req.Method, req.URL, ErrNoInteraction), true}
+}
+
+// replayError is a replayed or replay failure, permanent when it was at
+// record time
+type replayError struct {
+	err       error
+	permanent bool
+}
+
+func (e replayError) Error() string   { return e.err.Error() }
+func (e replayError) Unwrap() error   { return e.err }
+func (e replayError) Permanent() bool { return e.permanent }
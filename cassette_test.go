@@ -0,0 +1,124 @@
+package retrigo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCassetteTransport(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "hello" {
+			t.Errorf("bad body: %s", body)
+		}
+		if atomic.AddInt32(&hits, 1) < 3 {
+			w.WriteHeader(503)
+			return
+		}
+		w.Header().Set("X-Test", "foo")
+		w.Write([]byte("world"))
+	}))
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	durl := "http://127.0.0.1:1/foo " + ts.URL + "/foo"
+
+	// Record the retry sequence, the connection error included
+	codes := []int{}
+	client := NewClient()
+	client.HTTPClient.Transport = NewRecorder(path, client.HTTPClient.Transport)
+	client.CheckForRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if resp != nil {
+			codes = append(codes, resp.StatusCode)
+		} else {
+			codes = append(codes, 0)
+		}
+		return DefaultRetryPolicy(ctx, resp, err)
+	}
+	resp, err := client.Post(durl, "text/plain", []byte("hello"))
+	checkErr(t, err, true)
+	resp.Body.Close()
+	ts.Close()
+	recorded := codes
+
+	// Replay it with the server gone
+	replayer, err := NewReplayer(path)
+	checkErr(t, err, true)
+	replayer.Matchers = []Matcher{MatchMethod, MatchURL, MatchBody, MatchHeaders("Content-Type")}
+	if len(replayer.Interactions) != len(recorded) {
+		t.Fatalf("recorded %d interactions, expected %d", len(replayer.Interactions), len(recorded))
+	}
+	codes = []int{}
+	client.HTTPClient.Transport = replayer
+	resp, err = client.Post(durl, "text/plain", []byte("hello"))
+	checkErr(t, err, true)
+	body, err := io.ReadAll(resp.Body)
+	checkErr(t, err, true)
+	resp.Body.Close()
+	if string(body) != "world" || resp.Header.Get("X-Test") != "foo" {
+		t.Fatalf("bad response: %s %v", body, resp.Header)
+	}
+	if len(codes) != len(recorded) {
+		t.Fatalf("bad replay: %v, recorded %v", codes, recorded)
+	}
+	for i := range codes {
+		if codes[i] != recorded[i] {
+			t.Fatalf("bad replay: %v, recorded %v", codes, recorded)
+		}
+	}
+
+	// Every interaction was served, a different body doesn't match anyway
+	replayer, err = NewReplayer(path)
+	checkErr(t, err, true)
+	replayer.Matchers = []Matcher{MatchBody}
+	client.HTTPClient.Transport = replayer
+	_, err = client.Post(durl, "text/plain", []byte("bye"))
+	if !errors.Is(err, ErrNoInteraction) {
+		t.Fatalf("expected ErrNoInteraction, got: %v", err)
+	}
+}
+
+func TestCassetteTransport_permanentAndRedacted(t *testing.T) {
+	// The certificate of the server isn't trusted, which isn't retried
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	client := NewClient()
+	client.RetryMax = 2
+	client.HTTPClient.Transport = NewRecorder(path, client.HTTPClient.Transport)
+	req, err := NewRequest("GET", ts.URL, nil)
+	checkErr(t, err, true)
+	req.Header.Set("Authorization", "Bearer secret")
+	_, err = client.Do(req)
+	checkErr(t, err, false)
+
+	data, err := os.ReadFile(path)
+	checkErr(t, err, true)
+	if bytes.Contains(data, []byte("secret")) {
+		t.Fatalf("authorization recorded: %s", data)
+	}
+
+	replayer, err := NewReplayer(path)
+	checkErr(t, err, true)
+	if len(replayer.Interactions) != 1 || !replayer.Interactions[0].Permanent {
+		t.Fatalf("bad interactions: %+v", replayer.Interactions)
+	}
+	if got := replayer.Interactions[0].Request.Header.Get("Authorization"); got != Redacted {
+		t.Fatalf("bad authorization: %q", got)
+	}
+	client.HTTPClient.Transport = replayer
+	_, err = client.Do(req)
+	checkErr(t, err, false)
+	if errors.Is(err, ErrNoInteraction) || req.Attempts() != 1 {
+		t.Fatalf("expected a single permanent failure, got %d attempts: %v", req.Attempts(), err)
+	}
+}
@@ -25,6 +25,8 @@ package retrigo
 import (
 	"bytes"
 	"context"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -32,7 +34,9 @@ import (
 	"math/rand"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/go-cleanhttp"
@@ -53,9 +57,31 @@ var (
 	// limit the size we consume to respReadLimit.
 	respReadLimit = int64(4096)
 
+	// ErrGiveUp is returned by Client.Do, wrapped, when every attempt failed
+	ErrGiveUp = errors.New("giving up")
+
 	// FirstTarget in the first index that is going to be used when trying
 	// to reach the target url from the urls slice
 	FirstTarget = 0
+
+	// A regular expression to match the error returned by net/http when the
+	// configured number of redirects is exhausted. This error isn't typed
+	// specifically so we resort to matching on the error string.
+	redirectsErrorRe = regexp.MustCompile(`stopped after \d+ redirects\z`)
+
+	// A regular expression to match the error returned by net/http when the
+	// scheme specified in the URL is invalid. This error isn't typed
+	// specifically so we resort to matching on the error string.
+	schemeErrorRe = regexp.MustCompile(`unsupported protocol scheme`)
+
+	// A regular expression to match the error returned by net/http when a
+	// header name or value is invalid.
+	invalidHeaderErrorRe = regexp.MustCompile(`invalid header`)
+
+	// A regular expression to match the error returned by net/http when the
+	// TLS certificate is not trusted. When the certificate is not trusted the
+	// error is not always typed, so we match on the error string as well.
+	notTrustedErrorRe = regexp.MustCompile(`certificate is not trusted`)
 )
 
 // CheckForRetry is called following each request, it receives the http.Response
@@ -79,6 +105,59 @@ type Client struct {
 	// Scheduler specifies a the which of the supplied targets should be used next, it's called
 	// before each request. The default Scheduler is DefaultScheduler
 	Scheduler Scheduler
+
+	// TargetScheduler, when set, is used instead of Scheduler. It is handed
+	// the request and its targets with their metadata.
+	TargetScheduler TargetScheduler
+
+	// Targets, when set, supplies the base targets of requests which were
+	// created with a relative url, such as c.Get("/api/x"). The path and
+	// query of the request are resolved against the base picked for each
+	// attempt. The list is read once per Do.
+	Targets TargetSource
+
+	// Limiter, when set, bounds the attempts in flight. When the target picked
+	// by the scheduler has no free slot Do asks the scheduler for another
+	// target, and waits for a slot once every target was tried.
+	Limiter Limiter
+
+	// RateLimiter, when set, paces the attempts, retries included.
+	RateLimiter RateLimiter
+
+	// Compression, when set to gzip or deflate, compresses the request
+	// bodies of at least CompressionMinSize bytes, or of unknown size, once
+	// before the first attempt. See Request.Compress.
+	Compression        string
+	CompressionMinSize int64
+
+	// TokenSource, when set, supplies the Authorization header of every
+	// attempt. A 401 response makes Do fetch a new token and try again,
+	// once per Do, without counting that attempt against RetryMax.
+	TokenSource TokenSource
+
+	// Signer, when set, signs every attempt right before it is sent. Its
+	// errors are returned right away, without retrying. The token requests
+	// of ClientCredentials aren't signed, and along a TokenSource the
+	// signature must go in another header, see HMACSigner.Header.
+	Signer Signer
+
+	// Codecs encode and decode the bodies of the typed helpers, such as
+	// DoAs and NewEncodedRequest, by media type. DefaultCodecs when nil.
+	Codecs map[string]Codec
+
+	// MaxBodySize bounds the response bodies decoded by the typed helpers,
+	// DefaultMaxBodySize when zero.
+	MaxBodySize int64
+
+	// RetryDecodeErrors makes the typed helpers retry when reading the
+	// response body fails or it doesn't decode, as with a truncated body.
+	// Those retries share the RetryMax budget of the request.
+	RetryDecodeErrors bool
+
+	// inflight counts the attempts in progress per target scheme and host,
+	// dropping targets with none
+	mu       sync.Mutex
+	inflight map[string]int
 }
 
 // Backoff specifies a policy for how long to wait between retries.
@@ -93,7 +172,18 @@ type ReaderFunc func() (io.Reader, error)
 type Request struct {
 	body ReaderFunc
 	*http.Request
-	urls []string
+	urls    []string
+	targets []*Target
+	// targets whose attempts failed during the current Do
+	failed map[*Target]bool
+	// number of attempts made by the last Do
+	attempts int
+	// overrides Client.RetryMax when not nil, so a Download spreads a single
+	// retry budget over its reconnects
+	retryMax *int
+	// skips the client TokenSource and Signer, for the requests fetching
+	// tokens
+	anonymous bool
 }
 
 // LenReader is an interface implemented by many in-memory io.Reader's. Used
@@ -115,6 +205,11 @@ type Logger func(req *Request, mtype, msg string, err error)
 // Scheduler is for returning the next target and index for the Do function
 type Scheduler func(servers []string, i int) (string, int)
 
+// TargetScheduler is for returning the next target and index for the Do
+// function, like Scheduler but with access to the request and the targets
+// metadata. Request.Failed reports the targets already tried without success.
+type TargetScheduler func(req *Request, targets []*Target, i int) (*Target, int)
+
 // DefaultBackoff provides a default callback for Client.Backoff which
 // will perform exponential backoff based on the attempt number and limited
 // by the provided minimum and maximum durations.
@@ -172,7 +267,7 @@ func DefaultRetryPolicy(ctx context.Context, r *http.Response, err error) (bool,
 	}
 
 	if err != nil {
-		return true, err
+		return IsRetryableError(err), err
 	}
 	if r.StatusCode == 0 || (r.StatusCode >= 500 && r.StatusCode != 501) {
 		return true, nil
@@ -181,6 +276,53 @@ func DefaultRetryPolicy(ctx context.Context, r *http.Response, err error) (bool,
 	return false, nil
 }
 
+// IsRetryableError reports whether err, as returned by an http.Client, is worth
+// retrying. Connection, DNS and timeout failures are considered transient,
+// while malformed URLs, unsupported schemes, invalid headers, exhausted
+// redirects and TLS certificate verification failures are permanent and will
+// not go away by trying again. So are errors with a Permanent method
+// returning true, which transports can use to rule out retries.
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var perr interface{ Permanent() bool }
+	if errors.As(err, &perr) && perr.Permanent() {
+		return false
+	}
+
+	var uaerr x509.UnknownAuthorityError
+	if errors.As(err, &uaerr) {
+		return false
+	}
+	var cierr x509.CertificateInvalidError
+	if errors.As(err, &cierr) {
+		return false
+	}
+	var herr x509.HostnameError
+	if errors.As(err, &herr) {
+		return false
+	}
+
+	// Malformed URLs surface as a *url.Error from url.Parse
+	var uerr *url.Error
+	if errors.As(err, &uerr) && uerr.Op == "parse" {
+		return false
+	}
+
+	msg := err.Error()
+	switch {
+	case redirectsErrorRe.MatchString(msg),
+		schemeErrorRe.MatchString(msg),
+		invalidHeaderErrorRe.MatchString(msg),
+		notTrustedErrorRe.MatchString(msg):
+		return false
+	}
+
+	return true
+}
+
 // DefaultLogger is a simple logger
 func DefaultLogger(req *Request, mtype, msg string, err error) {
 	if err != nil {
@@ -321,33 +463,24 @@ func FromRequest(r *http.Request, durl string) (*Request, error) {
 	if err != nil {
 		return nil, err
 	}
-	dest := strings.Split(durl, " ")
-	// Could assert contentLength == r.ContentLength
-	return &Request{bodyReader, r, dest}, nil
-}
-
-// NewRequest create a wrapped request
-func NewRequest(method, durl string, rawBody interface{}) (*Request, error) {
-	bodyReader, contentLength, err := getBodyReaderAndContentLength(rawBody)
+	targets, err := parseTargets(durl)
 	if err != nil {
 		return nil, err
 	}
+	// Could assert contentLength == r.ContentLength
+	return &Request{body: bodyReader, Request: r, urls: targetURLs(targets), targets: targets}, nil
+}
 
+// NewRequest create a wrapped request, durl is a space separated list of urls
+// the request can be scheduled to. See NewRequestWithTargets for urls which
+// need to contain spaces or carry per target metadata.
+func NewRequest(method, durl string, rawBody interface{}) (*Request, error) {
 	// We need to validate all urls on the incoming string before proceeding.
-	dest := strings.Split(durl, " ")
-	for _, t := range dest {
-		_, err := url.Parse(t)
-		if err != nil {
-			return nil, err
-		}
-	}
-	// Then we build the http request with the first url on the resulting slice
-	httpReq, err := http.NewRequest(method, dest[0], nil)
+	targets, err := parseTargets(durl)
 	if err != nil {
 		return nil, err
 	}
-	httpReq.ContentLength = contentLength
-	return &Request{bodyReader, httpReq, dest}, nil
+	return newRequest(method, targets, rawBody)
 }
 
 // Try to read the response body so we can reuse this connection.
@@ -461,9 +594,172 @@ func (c *Client) Delete(durl string, bodyType string, body interface{}) (*http.R
 	return c.Do(req)
 }
 
-func parseURL(dest string) *url.URL {
-	u, _ := url.Parse(dest)
-	return u
+// schedule picks the target of the next attempt. t is nil when a Scheduler
+// returns an url that isn't one of the request targets.
+func (c *Client) schedule(req *Request, targets []*Target, urls []string, j int) (t *Target, dest string, next int) {
+	switch {
+	case c.TargetScheduler != nil:
+		t, next = c.TargetScheduler(req, targets, j)
+		return t, t.String(), next
+	case c.Scheduler != nil:
+		dest, next = c.Scheduler(urls, j)
+	default:
+		dest, next = DefaultScheduler(urls, j)
+	}
+	return lookupTarget(targets, urls, dest), dest, next
+}
+
+// targets returns the target list the attempts of req are scheduled over.
+// Requests without an absolute url use a snapshot of the client Targets
+// source, so a whole Do sees a consistent list even if the source changes,
+// and ref is the relative url to resolve against the picked base.
+func (c *Client) targets(req *Request) (targets []*Target, urls []string, ref *url.URL, err error) {
+	if c.Targets == nil || !req.relative() {
+		return req.targets, req.urls, nil, nil
+	}
+	targets, err = c.Targets.Targets(req.Context())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(targets) == 0 {
+		return nil, nil, nil, ErrNoTargets
+	}
+	return targets, targetURLs(targets), req.targets[0].URL, nil
+}
+
+// track counts an attempt to dest as in-flight until the returned function
+// is called.
+func (c *Client) track(dest string) func() {
+	key := targetKey(dest)
+	c.mu.Lock()
+	if c.inflight == nil {
+		c.inflight = make(map[string]int)
+	}
+	c.inflight[key]++
+	c.mu.Unlock()
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.inflight[key]--; c.inflight[key] <= 0 {
+			delete(c.inflight, key)
+		}
+	}
+}
+
+// acquire takes a Limiter slot for the attempt, moving to other targets from
+// the scheduler while the picked one is full and waiting for a slot once
+// every target was tried.
+func (c *Client) acquire(req *Request, targets []*Target, urls []string, t *Target, dest string, j int) (*Target, string, int, error) {
+	for k := 1; !c.Limiter.TryAcquire(dest); k++ {
+		if k >= len(targets) {
+			return t, dest, j, c.Limiter.Acquire(req.Context(), dest)
+		}
+		t, dest, j = c.schedule(req, targets, urls, j)
+	}
+	return t, dest, j, nil
+}
+
+// InFlight returns the number of attempts to target currently in progress,
+// that is waiting for the response headers. Attempts are counted per scheme
+// and host, whatever their path.
+func (c *Client) InFlight(target string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.inflight[targetKey(target)]
+}
+
+// Attempts returns the number of attempts made by the last Do of r, not
+// counting the one retried with a new token after a 401
+func (r *Request) Attempts() int {
+	return r.attempts
+}
+
+// Failed reports whether an attempt to t failed during the current Do
+func (r *Request) Failed(t *Target) bool {
+	return r.failed[t]
+}
+
+// route points req at dest, applying the Host and Header overrides of the
+// target t on top of the caller supplied header and host. When ref is not nil
+// dest is a base url ref is resolved against.
+func (r *Request) route(t *Target, dest string, ref *url.URL, header http.Header, host string) error {
+	r.Header, r.Host = header, host
+	if t == nil {
+		// The Scheduler came up with a url that isn't on the list
+		u, err := url.Parse(dest)
+		if err != nil {
+			return err
+		}
+		if ref != nil {
+			u = joinURL(u, ref)
+		}
+		r.URL = u
+		return nil
+	}
+
+	if ref != nil {
+		r.URL = joinURL(t.URL, ref)
+	} else {
+		u := *t.URL
+		r.URL = &u
+	}
+	if t.Host != "" {
+		r.Host = t.Host
+	}
+	if len(t.Header) > 0 {
+		r.Header = header.Clone()
+		for k, v := range t.Header {
+			r.Header[k] = v
+		}
+	}
+	return nil
+}
+
+// rewind sets the request Body to a fresh reader over the body, if any
+func (r *Request) rewind() error {
+	if r.body == nil {
+		return nil
+	}
+	body, err := r.body()
+	if err != nil {
+		return err
+	}
+	if c, ok := body.(io.ReadCloser); ok {
+		r.Body = c
+	} else {
+		r.Body = io.NopCloser(body)
+	}
+	return nil
+}
+
+// closeBody closes the body of an attempt which won't be sent, so streamed
+// bodies such as multipart forms release what they hold
+func (r *Request) closeBody() {
+	if r.Body != nil {
+		r.Body.Close()
+	}
+}
+
+// sign hands the attempt to the Signer with a fresh reader over the body,
+// rewinding the body again afterwards since some readers share their state.
+// The headers are copied first so the signature doesn't leak into the
+// caller's headers or the next attempt.
+func (c *Client) sign(req *Request) error {
+	req.Header = req.Header.Clone()
+	if req.body == nil {
+		return c.Signer.Sign(req.Request, http.NoBody)
+	}
+	body, err := req.body()
+	if err != nil {
+		return err
+	}
+	err = c.Signer.Sign(req.Request, body)
+	closeReader(body)
+	if err != nil {
+		return err
+	}
+	req.closeBody()
+	return req.rewind()
 }
 
 // Do wraps calling an HTTP method with retries.
@@ -474,28 +770,110 @@ func (c *Client) Do(req *Request) (*http.Response, error) {
 
 	j := FirstTarget
 
+	if c.Compression != "" && ((req.ContentLength > 0 && req.ContentLength >= c.CompressionMinSize) || req.sizeUnknown()) {
+		if err := req.Compress(c.Compression); err != nil {
+			return nil, err
+		}
+	}
+
+	// Target overrides are applied per attempt, restore the caller's view once
+	// we are done.
+	header, host := req.Header, req.Host
+	defer func() {
+		req.Header, req.Host = header, host
+	}()
+
+	targets, urls, ref, err := c.targets(req)
+	if err != nil {
+		return nil, err
+	}
+	req.failed = make(map[*Target]bool)
+	req.attempts = 0
+	retryMax := c.RetryMax
+	if req.retryMax != nil {
+		retryMax = *req.retryMax
+	}
+
+	// rejected is the token of the attempt a 401 answered, for which the
+	// TokenSource is asked a new one on the next attempt, at most once per Do
+	var token, rejected *Token
+	var refreshed bool
 	var resp *http.Response
-	for i := 0; i <= c.RetryMax; i++ {
+	for i := 0; i <= retryMax; i++ {
 		var code int // HTTP response code
 
 		// Always rewind the request body when non-nil.
-		if req.body != nil {
-			body, err := req.body()
-			if err != nil {
-				c.HTTPClient.CloseIdleConnections()
-				return resp, err
+		if err := req.rewind(); err != nil {
+			c.HTTPClient.CloseIdleConnections()
+			return resp, err
+		}
+		var t *Target
+		dest := ""
+		t, dest, j = c.schedule(req, targets, urls, j)
+		if c.Limiter != nil {
+			if t, dest, j, err = c.acquire(req, targets, urls, t, dest, j); err != nil {
+				req.closeBody()
+				return nil, err
 			}
-			if c, ok := body.(io.ReadCloser); ok {
-				req.Body = c
-			} else {
-				req.Body = io.NopCloser(body)
+		}
+		if c.RateLimiter != nil {
+			if err = c.RateLimiter.Wait(req.Context(), dest); err != nil {
+				if c.Limiter != nil {
+					c.Limiter.Release(dest, nil, nil)
+				}
+				req.closeBody()
+				return nil, err
 			}
 		}
-		dest := ""
-		dest, j = c.Scheduler(req.urls, j)
-		req.URL = parseURL(dest)
-		// Attempt the request
-		r, err := c.HTTPClient.Do(req.Request)
+		req.attempts++
+		var r *http.Response
+		err := req.route(t, dest, ref, header, host)
+		if err == nil && c.TokenSource != nil && !req.anonymous {
+			if token, err = c.authorize(req, rejected); err != nil {
+				if c.Limiter != nil {
+					c.Limiter.Release(dest, nil, nil)
+				}
+				req.closeBody()
+				return nil, err
+			}
+			rejected = nil
+		}
+		if err == nil && c.Signer != nil && !req.anonymous {
+			// A signer failing won't do better on the next attempt
+			if err = c.sign(req); err != nil {
+				if c.Limiter != nil {
+					c.Limiter.Release(dest, nil, nil)
+				}
+				req.closeBody()
+				return nil, fmt.Errorf("%s %s: signing: %w", req.Method, req.URL, err)
+			}
+		}
+		if err == nil {
+			// Attempt the request
+			done := c.track(dest)
+			r, err = c.HTTPClient.Do(req.Request)
+			done()
+		} else {
+			req.closeBody()
+		}
+		if c.Limiter != nil {
+			c.Limiter.Release(dest, r, err)
+		}
+		if c.RateLimiter != nil {
+			c.RateLimiter.Observe(dest, r, err)
+		}
+		if err == nil && r.StatusCode == http.StatusUnauthorized && c.TokenSource != nil && !req.anonymous && !refreshed {
+			// The token may have expired in flight, try again once with a
+			// fresh one without counting the attempt against RetryMax
+			c.drainBody(r.Body)
+			mtype := "DEBUG"
+			msg := fmt.Sprintf("%s %s status: 401: retrying with a new token", req.Method, req.URL)
+			c.Logger(req, mtype, msg, nil)
+			rejected, refreshed = token, true
+			req.attempts--
+			i--
+			continue
+		}
 		if err != nil {
 			mtype := "ERROR"
 			msg := fmt.Sprintf("%s %s request failed: ", req.Method, req.URL)
@@ -516,8 +894,11 @@ func (c *Client) Do(req *Request) (*http.Response, error) {
 		if err == nil {
 			c.drainBody(r.Body)
 		}
+		if t != nil {
+			req.failed[t] = true
+		}
 
-		remain := c.RetryMax - i
+		remain := retryMax - i
 		if remain == 0 {
 			break
 		}
@@ -529,8 +910,15 @@ func (c *Client) Do(req *Request) (*http.Response, error) {
 		mtype := "DEBUG"
 		msg := fmt.Sprintf("%s: retrying in %s (%d left): ", desc, wait, remain)
 		c.Logger(req, mtype, msg, err)
-		time.Sleep(wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			c.HTTPClient.CloseIdleConnections()
+			return nil, req.Context().Err()
+		}
 	}
 
-	return nil, fmt.Errorf("%s %s giving up after %d attempts", req.Method, req.URL, c.RetryMax)
+	return nil, fmt.Errorf("%s %s %w after %d attempts", req.Method, req.URL, ErrGiveUp, retryMax)
 }
@@ -3,6 +3,7 @@ package retrigo
 import (
 	"bytes"
 	"context"
+	"crypto/x509"
 	"errors"
 	"io"
 	"net"
@@ -1266,3 +1267,69 @@ func TestClient_BackoffCustom(t *testing.T) {
 		t.Fatalf("expected retries: %d != %d", client.RetryMax, retries)
 	}
 }
+
+// permanentError is a transport error telling whether it is permanent
+type permanentError bool
+
+func (e permanentError) Error() string   { return "transport error" }
+func (e permanentError) Permanent() bool { return bool(e) }
+
+func TestIsRetryableError(t *testing.T) {
+	cases := []struct {
+		err    error
+		expect bool
+	}{
+		{nil, false},
+		{errors.New("connection refused"), true},
+		{&url.Error{Op: "Get", URL: "http://foo", Err: errors.New("dial tcp: i/o timeout")}, true},
+		{&net.DNSError{Err: "no such host", Name: "foo", IsNotFound: true}, true},
+		{&url.Error{Op: "Get", URL: "http://foo", Err: errors.New("stopped after 10 redirects")}, false},
+		{&url.Error{Op: "Get", URL: "foo://bar", Err: errors.New(`unsupported protocol scheme "foo"`)}, false},
+		{&url.Error{Op: "parse", URL: "://foo", Err: errors.New("missing protocol scheme")}, false},
+		{&url.Error{Op: "Get", URL: "https://foo", Err: x509.UnknownAuthorityError{}}, false},
+		{&url.Error{Op: "Get", URL: "https://foo", Err: x509.HostnameError{Host: "foo"}}, false},
+		{&url.Error{Op: "Get", URL: "https://foo", Err: x509.CertificateInvalidError{Reason: x509.Expired}}, false},
+		{errors.New(`net/http: invalid header field value for "X-Foo"`), false},
+		{&url.Error{Op: "Get", URL: "http://foo", Err: permanentError(true)}, false},
+		{&url.Error{Op: "Get", URL: "http://foo", Err: permanentError(false)}, true},
+	}
+
+	for _, tc := range cases {
+		if v := IsRetryableError(tc.err); v != tc.expect {
+			t.Fatalf("bad: %v -> %v", tc.err, v)
+		}
+	}
+}
+
+func TestClient_Do_permanentErrors(t *testing.T) {
+	tls := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer tls.Close()
+
+	loop := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Path, http.StatusFound)
+	}))
+	defer loop.Close()
+
+	for _, durl := range []string{tls.URL, loop.URL + "/loop", "foo://bar"} {
+		client := NewClient()
+		client.RetryWaitMin = 10 * time.Millisecond
+		client.RetryWaitMax = 10 * time.Millisecond
+
+		// Count the attempts, there should be only one
+		called := 0
+		client.CheckForRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+			called++
+			return DefaultRetryPolicy(ctx, resp, err)
+		}
+
+		_, err := client.Get(durl)
+		if err == nil || strings.Contains(err.Error(), "giving up") {
+			t.Fatalf("expected permanent error for %s, got: %v", durl, err)
+		}
+		if called != 1 {
+			t.Fatalf("%s: CheckRetry called %d times, expected 1", durl, called)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// Command retrigo is a curl-like HTTP client with automatic retries and
+// multiple target failover, backed by the retrigo library.
+//
+// Usage:
+//
+//	retrigo [flags] URL [URL...]
+//
+// Every URL is a target for the same request, attempts are spread over them
+// by the default scheduler. Exit codes are 0 on success, 2 on usage errors,
+// 22 when the final response is an HTTP error (400 and above), 75 when every
+// attempt failed and 1 on any other error.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wolviecb/retrigo"
+)
+
+const (
+	exitOK        = 0
+	exitError     = 1
+	exitUsage     = 2
+	exitHTTPError = 22
+	exitGiveUp    = 75
+)
+
+// headers collects repeated -H flags
+type headers []string
+
+func (h *headers) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headers) Set(v string) error {
+	if !strings.Contains(v, ":") {
+		return fmt.Errorf("bad header %q, expected \"Name: value\"", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+// retryOn is a retry policy for a list of status codes or classes such as
+// 503 or 5xx. Transport errors are retried when IsRetryableError says so.
+func retryOn(spec string) (retrigo.CheckForRetry, error) {
+	var codes []string
+	for _, c := range strings.Split(spec, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c == "" {
+			continue
+		}
+		valid := len(c) == 3 && c[0] >= '1' && c[0] <= '5'
+		if valid && c[1:] != "xx" {
+			_, err := strconv.Atoi(c)
+			valid = err == nil
+		}
+		if !valid {
+			return nil, fmt.Errorf("bad status %q, expected e.g. 503 or 5xx", c)
+		}
+		codes = append(codes, c)
+	}
+
+	return func(ctx context.Context, r *http.Response, err error) (bool, error) {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		if err != nil {
+			return retrigo.IsRetryableError(err), err
+		}
+		status := strconv.Itoa(r.StatusCode)
+		for _, c := range codes {
+			if c == status || (c[1:] == "xx" && c[0] == status[0]) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}, nil
+}
+
+// traceTransport prints every attempt to w
+type traceTransport struct {
+	rt http.RoundTripper
+	w  io.Writer
+	n  int
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.n++
+	start := time.Now()
+	fmt.Fprintf(t.w, "* attempt %d: %s %s\n", t.n, req.Method, req.URL)
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		fmt.Fprintf(t.w, "* attempt %d failed after %s: %v\n", t.n, time.Since(start).Round(time.Millisecond), err)
+		return nil, err
+	}
+	fmt.Fprintf(t.w, "* attempt %d: %s in %s\n", t.n, resp.Status, time.Since(start).Round(time.Millisecond))
+	return resp, nil
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("retrigo", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: retrigo [flags] URL [URL...]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+
+	var hdrs headers
+	method := fs.String("X", "", "request method, GET or POST when -d is given")
+	fs.Var(&hdrs, "H", "request header \"Name: value\", may be repeated")
+	data := fs.String("d", "", "request body, @file reads it from file and @- from stdin")
+	output := fs.String("o", "", "write the response body to file instead of stdout")
+	verbose := fs.Bool("v", false, "print every attempt and the response headers to stderr")
+	maxTime := fs.Duration("m", 0, "timeout of each attempt, e.g. 10s")
+	retryMax := fs.Int("retry-max", retrigo.DefaultRetryMax, "maximum number of retries")
+	waitMin := fs.Duration("wait-min", retrigo.DefaultRetryWaitMin, "minimum time to wait between retries")
+	waitMax := fs.Duration("wait-max", retrigo.DefaultRetryWaitMax, "maximum time to wait between retries")
+	backoff := fs.String("backoff", "exponential", "backoff policy, exponential or linear")
+	retryStatus := fs.String("retry-on", "", "comma separated statuses to retry, e.g. 429,5xx (default connection errors and 5xx but 501)")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	c := retrigo.NewClient()
+	c.RetryMax = *retryMax
+	c.RetryWaitMin = *waitMin
+	c.RetryWaitMax = *waitMax
+	c.HTTPClient.Timeout = *maxTime
+	c.Logger = func(req *retrigo.Request, mtype, msg string, err error) {}
+	if *verbose {
+		c.HTTPClient.Transport = &traceTransport{rt: c.HTTPClient.Transport, w: stderr}
+		c.Logger = func(req *retrigo.Request, mtype, msg string, err error) {
+			if mtype == "DEBUG" {
+				fmt.Fprintf(stderr, "* %s\n", strings.TrimSuffix(strings.TrimSpace(msg), ":"))
+			}
+		}
+	}
+
+	switch *backoff {
+	case "exponential":
+		c.Backoff = retrigo.DefaultBackoff
+	case "linear":
+		c.Backoff = retrigo.LinearJitterBackoff
+	default:
+		fmt.Fprintf(stderr, "retrigo: unknown backoff %q\n", *backoff)
+		return exitUsage
+	}
+
+	if *retryStatus != "" {
+		policy, err := retryOn(*retryStatus)
+		if err != nil {
+			fmt.Fprintf(stderr, "retrigo: %v\n", err)
+			return exitUsage
+		}
+		c.CheckForRetry = policy
+	}
+
+	targets := make([]*retrigo.Target, fs.NArg())
+	for i, arg := range fs.Args() {
+		u, err := url.Parse(arg)
+		if err != nil {
+			fmt.Fprintf(stderr, "retrigo: %v\n", err)
+			return exitUsage
+		}
+		targets[i] = &retrigo.Target{URL: u}
+	}
+
+	var body interface{}
+	if isSet(fs, "d") {
+		var raw []byte
+		var err error
+		switch {
+		case *data == "@-":
+			raw, err = io.ReadAll(stdin)
+		case strings.HasPrefix(*data, "@"):
+			raw, err = os.ReadFile((*data)[1:])
+		default:
+			raw = []byte(*data)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "retrigo: %v\n", err)
+			return exitError
+		}
+		body = raw
+		if *method == "" {
+			*method = http.MethodPost
+		}
+	}
+	if *method == "" {
+		*method = http.MethodGet
+	}
+
+	req, err := retrigo.NewRequestWithTargets(*method, targets, body)
+	if err != nil {
+		fmt.Fprintf(stderr, "retrigo: %v\n", err)
+		return exitUsage
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for _, h := range hdrs {
+		kv := strings.SplitN(h, ":", 2)
+		req.Header.Set(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		fmt.Fprintf(stderr, "retrigo: %v\n", err)
+		if errors.Is(err, retrigo.ErrGiveUp) {
+			return exitGiveUp
+		}
+		return exitError
+	}
+	defer resp.Body.Close()
+
+	if *verbose {
+		fmt.Fprintf(stderr, "< %s %s\n", resp.Proto, resp.Status)
+		for k, vs := range resp.Header {
+			for _, v := range vs {
+				fmt.Fprintf(stderr, "< %s: %s\n", k, v)
+			}
+		}
+	}
+
+	out := stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(stderr, "retrigo: %v\n", err)
+			return exitError
+		}
+		defer f.Close()
+		out = f
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		fmt.Fprintf(stderr, "retrigo: %v\n", err)
+		return exitError
+	}
+
+	if resp.StatusCode >= 400 {
+		fmt.Fprintf(stderr, "retrigo: %s %s: %s\n", req.Method, req.URL, resp.Status)
+		return exitHTTPError
+	}
+	return exitOK
+}
+
+// isSet reports whether the flag name was given on the command line
+func isSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
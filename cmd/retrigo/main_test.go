@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			if atomic.AddInt32(&hits, 1) < 3 {
+				w.WriteHeader(503)
+				return
+			}
+		case "/echo":
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("X-Method", r.Method)
+			w.Write([]byte(r.Header.Get("X-Test") + ":"))
+			w.Write(body)
+			return
+		case "/missing":
+			w.WriteHeader(404)
+			return
+		case "/busy":
+			w.WriteHeader(429)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	bodyFile := filepath.Join(dir, "body")
+	if err := os.WriteFile(bodyFile, []byte("from file"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	outFile := filepath.Join(dir, "out")
+
+	cases := []struct {
+		args   []string
+		stdin  string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{[]string{}, "", exitUsage, "", "Usage"},
+		{[]string{"-backoff", "cubic", ts.URL}, "", exitUsage, "", "unknown backoff"},
+		{[]string{"-retry-on", "5x3", ts.URL}, "", exitUsage, "", "bad status"},
+		{[]string{"ftp://foo"}, "", exitUsage, "", "unsupported scheme"},
+		{[]string{"--wait-min", "1ms", "--wait-max", "1ms", "-v", ts.URL + "/flaky"}, "", exitOK, "ok", "attempt 3: 200 OK"},
+		{[]string{"-H", "X-Test: foo", "-d", "hello", ts.URL + "/echo"}, "", exitOK, "foo:hello", ""},
+		{[]string{"-X", "PUT", "-d", "@" + bodyFile, "-v", ts.URL + "/echo"}, "", exitOK, ":from file", "X-Method: PUT"},
+		{[]string{"-d", "@-", ts.URL + "/echo"}, "from stdin", exitOK, ":from stdin", ""},
+		{[]string{ts.URL + "/missing"}, "", exitHTTPError, "", "404"},
+		{[]string{"--retry-max", "2", "--wait-min", "1ms", "--wait-max", "1ms", "http://127.0.0.1:1", "http://127.0.0.2:1"}, "", exitGiveUp, "", "giving up"},
+		{[]string{"--retry-max", "2", "--wait-min", "1ms", "--retry-on", "429,5xx", ts.URL + "/busy"}, "", exitGiveUp, "", "giving up"},
+		{[]string{"-o", outFile, ts.URL}, "", exitOK, "", ""},
+	}
+
+	for _, tc := range cases {
+		var stdout, stderr bytes.Buffer
+		code := run(tc.args, strings.NewReader(tc.stdin), &stdout, &stderr)
+		if code != tc.code {
+			t.Fatalf("%v: exit %d, expected %d (%s)", tc.args, code, tc.code, stderr.String())
+		}
+		if stdout.String() != tc.stdout {
+			t.Fatalf("%v: bad stdout: %q", tc.args, stdout.String())
+		}
+		if !strings.Contains(stderr.String(), tc.stderr) {
+			t.Fatalf("%v: bad stderr: %q", tc.args, stderr.String())
+		}
+	}
+
+	out, err := os.ReadFile(outFile)
+	if err != nil || string(out) != "ok" {
+		t.Fatalf("bad output file: %q %v", out, err)
+	}
+}
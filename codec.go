@@ -0,0 +1,230 @@
+package retrigo
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+var (
+	// ErrNoCodec is returned, wrapped, when no codec is registered for a
+	// content type
+	ErrNoCodec = errors.New("no codec for content type")
+
+	// DefaultCodecs are the codecs used by clients without Codecs, by media
+	// type
+	DefaultCodecs = map[string]Codec{
+		"application/json":                  JSONCodec{},
+		"application/xml":                   XMLCodec{},
+		"text/xml":                          XMLCodec{},
+		"application/x-www-form-urlencoded": FormCodec{},
+		"application/x-ndjson":              NDJSONCodec{},
+	}
+)
+
+// Codec encodes and decodes request and response bodies of a content type
+type Codec interface {
+	ContentType() string                     // Content type of the encoded bodies
+	Encode(w io.Writer, v interface{}) error // Encode writes v to w
+	Decode(r io.Reader, v interface{}) error // Decode reads r into v, a pointer
+}
+
+// JSONCodec encodes values as JSON
+type JSONCodec struct{}
+
+// ContentType returns application/json
+func (JSONCodec) ContentType() string { return "application/json" }
+
+// Encode writes v as JSON, without a trailing newline
+func (JSONCodec) Encode(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Decode reads a JSON value into v
+func (JSONCodec) Decode(r io.Reader, v interface{}) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// XMLCodec encodes values as XML
+type XMLCodec struct{}
+
+// ContentType returns application/xml
+func (XMLCodec) ContentType() string { return "application/xml" }
+
+// Encode writes v as XML
+func (XMLCodec) Encode(w io.Writer, v interface{}) error {
+	return xml.NewEncoder(w).Encode(v)
+}
+
+// Decode reads an XML document into v
+func (XMLCodec) Decode(r io.Reader, v interface{}) error {
+	return xml.NewDecoder(r).Decode(v)
+}
+
+// FormCodec encodes url.Values, map[string][]string and map[string]string
+// as url-encoded forms, and decodes forms into *url.Values and
+// *map[string][]string
+type FormCodec struct{}
+
+// ContentType returns application/x-www-form-urlencoded
+func (FormCodec) ContentType() string { return "application/x-www-form-urlencoded" }
+
+// Encode writes v as an url-encoded form
+func (FormCodec) Encode(w io.Writer, v interface{}) error {
+	var form url.Values
+	switch v := v.(type) {
+	case url.Values:
+		form = v
+	case map[string][]string:
+		form = v
+	case map[string]string:
+		form = make(url.Values, len(v))
+		for k, s := range v {
+			form.Set(k, s)
+		}
+	default:
+		return fmt.Errorf("form codec: cannot encode %T", v)
+	}
+	_, err := io.WriteString(w, form.Encode())
+	return err
+}
+
+// Decode reads an url-encoded form into v
+func (FormCodec) Decode(r io.Reader, v interface{}) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	form, err := url.ParseQuery(string(b))
+	if err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case *url.Values:
+		*v = form
+	case *map[string][]string:
+		*v = form
+	default:
+		return fmt.Errorf("form codec: cannot decode into %T", v)
+	}
+	return nil
+}
+
+// NDJSONCodec encodes slices as newline delimited JSON, one element per
+// line, and decodes such streams into pointers to slices
+type NDJSONCodec struct{}
+
+// ContentType returns application/x-ndjson
+func (NDJSONCodec) ContentType() string { return "application/x-ndjson" }
+
+// Encode writes the elements of the slice v, one JSON value per line
+func (NDJSONCodec) Encode(w io.Writer, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return fmt.Errorf("ndjson codec: cannot encode %T", v)
+	}
+	enc := json.NewEncoder(w)
+	for i := 0; i < rv.Len(); i++ {
+		if err := enc.Encode(rv.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decode appends every JSON value read from r to the slice v points to
+func (NDJSONCodec) Decode(r io.Reader, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("ndjson codec: cannot decode into %T", v)
+	}
+	slice := rv.Elem()
+	dec := json.NewDecoder(r)
+	for {
+		elem := reflect.New(slice.Type().Elem())
+		err := dec.Decode(elem.Interface())
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		slice.Set(reflect.Append(slice, elem.Elem()))
+	}
+}
+
+// RegisterCodec adds codec to the client codecs, under its content type,
+// starting from DefaultCodecs. It must not be called while c is in use.
+func (c *Client) RegisterCodec(codec Codec) {
+	if c.Codecs == nil {
+		c.Codecs = make(map[string]Codec, len(DefaultCodecs)+1)
+		for k, v := range DefaultCodecs {
+			c.Codecs[k] = v
+		}
+	}
+	media, _, err := mime.ParseMediaType(codec.ContentType())
+	if err != nil {
+		media = strings.ToLower(codec.ContentType())
+	}
+	c.Codecs[media] = codec
+}
+
+// Codec returns the codec for contentType, which may carry parameters such
+// as a charset. Structured syntax suffixes such as application/problem+json
+// fall back to the codec of the base syntax.
+func (c *Client) Codec(contentType string) (Codec, error) {
+	codecs := c.Codecs
+	if codecs == nil {
+		codecs = DefaultCodecs
+	}
+	media, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %v", ErrNoCodec, contentType, err)
+	}
+	if codec, ok := codecs[media]; ok {
+		return codec, nil
+	}
+	if i := strings.LastIndex(media, "+"); i >= 0 {
+		if codec, ok := codecs["application/"+media[i+1:]]; ok {
+			return codec, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrNoCodec, contentType)
+}
+
+// NewEncodedRequest creates a wrapped request whose body is v encoded by the
+// codec for contentType, which is set as the Content-Type and Accept headers.
+// The body is encoded once and replayed on every attempt.
+func (c *Client) NewEncodedRequest(method, durl, contentType string, v interface{}) (*Request, error) {
+	codec, err := c.Codec(contentType)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := codec.Encode(&buf, v); err != nil {
+		return nil, err
+	}
+	req, err := NewRequest(method, durl, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", contentType)
+	return req, nil
+}
@@ -0,0 +1,149 @@
+package retrigo
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type point struct {
+	XMLName xml.Name `xml:"point" json:"-"`
+	X       int      `xml:"x" json:"x"`
+	Y       int      `xml:"y" json:"y"`
+}
+
+func TestCodecs(t *testing.T) {
+	cases := []struct {
+		codec Codec
+		in    interface{}
+		out   interface{}
+		want  string
+	}{
+		{JSONCodec{}, point{X: 1, Y: 2}, &point{}, `{"x":1,"y":2}`},
+		{XMLCodec{}, point{X: 1, Y: 2}, &point{}, `<point><x>1</x><y>2</y></point>`},
+		{FormCodec{}, url.Values{"a": {"1", "2"}, "b": {"x y"}}, &url.Values{}, `a=1&a=2&b=x+y`},
+		{NDJSONCodec{}, []point{{X: 1}, {Y: 2}}, &[]point{}, "{\"x\":1,\"y\":0}\n{\"x\":0,\"y\":2}\n"},
+	}
+	for _, tc := range cases {
+		var buf bytes.Buffer
+		checkErr(t, tc.codec.Encode(&buf, tc.in), true)
+		if buf.String() != tc.want {
+			t.Fatalf("%s: encoded %q, expected %q", tc.codec.ContentType(), buf.String(), tc.want)
+		}
+		checkErr(t, tc.codec.Decode(&buf, tc.out), true)
+		got := reflect.ValueOf(tc.out).Elem().Interface()
+		if p, ok := got.(point); ok {
+			p.XMLName = xml.Name{}
+			got = p
+		}
+		if !reflect.DeepEqual(got, tc.in) {
+			t.Fatalf("%s: decoded %#v, expected %#v", tc.codec.ContentType(), got, tc.in)
+		}
+	}
+
+	if err := (FormCodec{}).Encode(io.Discard, 1); err == nil {
+		t.Fatal("expected error encoding an int as a form")
+	}
+}
+
+func TestClient_Codec(t *testing.T) {
+	client := NewClient()
+	for ct, want := range map[string]Codec{
+		"application/json; charset=utf-8": JSONCodec{},
+		"application/problem+json":        JSONCodec{},
+		"Text/XML":                        XMLCodec{},
+		"application/x-ndjson":            NDJSONCodec{},
+	} {
+		codec, err := client.Codec(ct)
+		checkErr(t, err, true)
+		if codec != want {
+			t.Fatalf("%s: got %T", ct, codec)
+		}
+	}
+	if _, err := client.Codec("text/csv"); !errors.Is(err, ErrNoCodec) {
+		t.Fatalf("expected ErrNoCodec, got %v", err)
+	}
+
+	client.RegisterCodec(csvCodec{})
+	if codec, err := client.Codec("text/csv"); err != nil || codec != (csvCodec{}) {
+		t.Fatalf("registered codec not found: %v", err)
+	}
+	if _, ok := DefaultCodecs["text/csv"]; ok {
+		t.Fatal("DefaultCodecs modified")
+	}
+}
+
+// csvCodec encodes [][]string as comma separated lines, counting encodes
+type csvCodec struct{}
+
+var csvEncodes int32
+
+func (csvCodec) ContentType() string { return "text/csv" }
+
+func (csvCodec) Encode(w io.Writer, v interface{}) error {
+	atomic.AddInt32(&csvEncodes, 1)
+	for _, row := range v.([][]string) {
+		if _, err := io.WriteString(w, strings.Join(row, ",")+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (csvCodec) Decode(r io.Reader, v interface{}) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	rows := v.(*[][]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		*rows = append(*rows, strings.Split(line, ","))
+	}
+	return nil
+}
+
+func TestClient_NewEncodedRequest(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "a,b\nc,d\n" || r.Header.Get("Content-Type") != "text/csv" {
+			t.Errorf("bad request: %q %s", body, r.Header.Get("Content-Type"))
+		}
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(503)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(`<point><x>3</x><y>4</y></point>`))
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.RegisterCodec(csvCodec{})
+	atomic.StoreInt32(&csvEncodes, 0)
+
+	req, err := client.NewEncodedRequest("POST", ts.URL, "text/csv", [][]string{{"a", "b"}, {"c", "d"}})
+	checkErr(t, err, true)
+	p, err := DoAs[point](client, req)
+	checkErr(t, err, true)
+	if p.X != 3 || p.Y != 4 {
+		t.Fatalf("bad point: %+v", p)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 2 {
+		t.Fatalf("expected 2 attempts, got %d", n)
+	}
+	if n := atomic.LoadInt32(&csvEncodes); n != 1 {
+		t.Fatalf("body encoded %d times", n)
+	}
+}
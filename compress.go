@@ -0,0 +1,143 @@
+package retrigo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+)
+
+var (
+	// CompressSpillSize is the size past which compressed request bodies are
+	// kept in a temporary file instead of memory
+	CompressSpillSize int64 = 4 << 20
+)
+
+// spillBuffer keeps what is written to it in memory, moving it to a
+// temporary file once it grows past limit
+type spillBuffer struct {
+	limit int64
+	size  int64
+	buf   bytes.Buffer
+	file  *os.File
+}
+
+func (s *spillBuffer) Write(p []byte) (int, error) {
+	if s.file == nil && s.size+int64(len(p)) > s.limit {
+		f, err := os.CreateTemp("", "retrigo-body-")
+		if err != nil {
+			return 0, err
+		}
+		// Unlinked right away, but left open for the attempts to read it:
+		// its descriptor, and its disk space, are only released once the
+		// request is garbage collected or the process exits. Where open
+		// files can't be removed, as on Windows, it is removed then too.
+		if os.Remove(f.Name()) != nil {
+			runtime.SetFinalizer(f, func(f *os.File) {
+				f.Close()
+				os.Remove(f.Name())
+			})
+		}
+		if _, err := f.Write(s.buf.Bytes()); err != nil {
+			f.Close()
+			return 0, err
+		}
+		s.file = f
+		s.buf = bytes.Buffer{}
+	}
+
+	var n int
+	var err error
+	if s.file != nil {
+		n, err = s.file.Write(p)
+	} else {
+		n, err = s.buf.Write(p)
+	}
+	s.size += int64(n)
+	return n, err
+}
+
+// readerFunc returns a ReaderFunc reading back what was written
+func (s *spillBuffer) readerFunc() ReaderFunc {
+	if f := s.file; f != nil {
+		size := s.size
+		return func() (io.Reader, error) {
+			return io.NewSectionReader(f, 0, size), nil
+		}
+	}
+	buf := s.buf.Bytes()
+	return func() (io.Reader, error) {
+		return bytes.NewReader(buf), nil
+	}
+}
+
+// newCompressor returns a writer compressing to w with encoding, gzip or
+// deflate
+func newCompressor(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "deflate":
+		// HTTP deflate is the zlib format
+		return zlib.NewWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+}
+
+// sizeUnknown reports whether the request has a body whose size isn't known
+// in advance, as with a ReaderFunc streaming it
+func (r *Request) sizeUnknown() bool {
+	if r.ContentLength < 0 {
+		return true
+	}
+	if r.body == nil || r.ContentLength > 0 {
+		return false
+	}
+	body, err := r.body()
+	if err != nil {
+		return false
+	}
+	defer closeReader(body)
+	_, ok := body.(LenReader)
+	return !ok
+}
+
+// Compress compresses the request body with encoding, gzip or deflate, and
+// sets the Content-Encoding and Content-Length headers to match. The body is
+// compressed once and the compressed bytes are replayed on every attempt,
+// kept in a temporary file when larger than CompressSpillSize. The file stays
+// open until the request is garbage collected, so each request with a large
+// compressed body holds a file descriptor until then. Requests without a
+// body, or whose body already has a Content-Encoding, are left alone.
+func (r *Request) Compress(encoding string) error {
+	if r.body == nil || r.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	sink := &spillBuffer{limit: CompressSpillSize}
+	zw, err := newCompressor(encoding, sink)
+	if err != nil {
+		return err
+	}
+
+	src, err := r.body()
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(zw, src)
+	closeReader(src)
+	if err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	r.body = sink.readerFunc()
+	r.ContentLength = sink.size
+	r.Header.Set("Content-Encoding", encoding)
+	return nil
+}
@@ -0,0 +1,178 @@
+package retrigo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// inflateServer records the compressed bodies it receives, failing the first
+// attempt, and checks they inflate to want
+func inflateServer(t *testing.T, want string, bodies *[][]byte, mu *sync.Mutex) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		if r.ContentLength != int64(len(raw)) {
+			t.Errorf("bad Content-Length: %d for %d bytes", r.ContentLength, len(raw))
+		}
+
+		var zr io.Reader
+		var err error
+		switch r.Header.Get("Content-Encoding") {
+		case "gzip":
+			zr, err = gzip.NewReader(bytes.NewReader(raw))
+		case "deflate":
+			zr, err = zlib.NewReader(bytes.NewReader(raw))
+		default:
+			t.Errorf("bad Content-Encoding: %q", r.Header.Get("Content-Encoding"))
+			return
+		}
+		if err != nil {
+			t.Errorf("bad compressed body: %v", err)
+			return
+		}
+		body, err := io.ReadAll(zr)
+		if err != nil || string(body) != want {
+			t.Errorf("bad body: %v, %d bytes", err, len(body))
+		}
+
+		mu.Lock()
+		*bodies = append(*bodies, raw)
+		n := len(*bodies)
+		mu.Unlock()
+		if n == 1 {
+			w.WriteHeader(503)
+		}
+	}))
+}
+
+func TestRequest_Compress(t *testing.T) {
+	payload := strings.Repeat("compress me ", 1000)
+	for _, encoding := range []string{"gzip", "deflate"} {
+		var mu sync.Mutex
+		var bodies [][]byte
+		ts := inflateServer(t, payload, &bodies, &mu)
+
+		req, err := NewRequest("POST", ts.URL, strings.NewReader(payload))
+		checkErr(t, err, true)
+		checkErr(t, req.Compress(encoding), true)
+		if req.ContentLength >= int64(len(payload)) {
+			t.Fatalf("%s: body not compressed: %d bytes", encoding, req.ContentLength)
+		}
+
+		client := NewClient()
+		client.RetryWaitMin = time.Millisecond
+		client.RetryWaitMax = time.Millisecond
+		resp, err := client.Do(req)
+		checkErr(t, err, true)
+		resp.Body.Close()
+		ts.Close()
+
+		if len(bodies) != 2 || !bytes.Equal(bodies[0], bodies[1]) {
+			t.Fatalf("%s: retry sent a different body", encoding)
+		}
+	}
+
+	req, err := NewRequest("POST", "http://localhost", []byte("x"))
+	checkErr(t, err, true)
+	if err := req.Compress("br"); err == nil {
+		t.Fatal("expected error for an unsupported encoding")
+	}
+}
+
+func TestRequest_Compress_spill(t *testing.T) {
+	defer func(size int64) { CompressSpillSize = size }(CompressSpillSize)
+	CompressSpillSize = 64
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	// Random-ish content compresses poorly, so it spills
+	payload := make([]byte, 10000)
+	for i := range payload {
+		payload[i] = byte(i*i + i/7)
+	}
+	var mu sync.Mutex
+	var bodies [][]byte
+	ts := inflateServer(t, string(payload), &bodies, &mu)
+	defer ts.Close()
+
+	req, err := NewRequest("PUT", ts.URL, payload)
+	checkErr(t, err, true)
+	checkErr(t, req.Compress("gzip"), true)
+	r, err := req.body()
+	checkErr(t, err, true)
+	if _, ok := r.(*io.SectionReader); !ok {
+		t.Fatalf("compressed body not spilled to disk: %T", r)
+	}
+	// The file is unlinked right away where the platform allows it
+	if entries, _ := os.ReadDir(tmp); runtime.GOOS != "windows" && len(entries) != 0 {
+		t.Fatalf("spilled body left in the temp dir: %v", entries)
+	}
+
+	client := NewClient()
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	resp, err := client.Do(req)
+	checkErr(t, err, true)
+	resp.Body.Close()
+	if len(bodies) != 2 || !bytes.Equal(bodies[0], bodies[1]) {
+		t.Fatal("retry sent a different body")
+	}
+}
+
+func TestClient_Compression(t *testing.T) {
+	var mu sync.Mutex
+	var bodies [][]byte
+	payload := strings.Repeat("a", 2000)
+	ts := inflateServer(t, payload, &bodies, &mu)
+	defer ts.Close()
+
+	client := NewClient()
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.Compression = "gzip"
+	client.CompressionMinSize = 1000
+	resp, err := client.Post(ts.URL, "text/plain", []byte(payload))
+	checkErr(t, err, true)
+	resp.Body.Close()
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(bodies))
+	}
+
+	// Small bodies are sent as they are
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "" {
+			t.Errorf("small body compressed")
+		}
+	}))
+	defer ts2.Close()
+	resp, err = client.Post(ts2.URL, "text/plain", []byte("small"))
+	checkErr(t, err, true)
+	resp.Body.Close()
+
+	// and so are empty ones, whatever the minimum size
+	client.CompressionMinSize = 0
+	resp, err = client.Post(ts2.URL, "text/plain", []byte{})
+	checkErr(t, err, true)
+	resp.Body.Close()
+
+	// while bodies of unknown size are compressed
+	bodies = nil
+	body := ReaderFunc(func() (io.Reader, error) {
+		return io.LimitReader(strings.NewReader(payload), int64(len(payload))), nil
+	})
+	resp, err = client.Post(ts.URL, "text/plain", body)
+	checkErr(t, err, true)
+	resp.Body.Close()
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(bodies))
+	}
+}
@@ -0,0 +1,182 @@
+package retrigo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotResumable is returned by the body of a Download when the rest of
+	// the body can't be fetched, because the server ignored the Range header
+	// or the resource changed since the download started
+	ErrNotResumable = errors.New("download can't be resumed")
+)
+
+// resumableBody is the body of a Download. When a read fails it asks for the
+// rest of the resource and carries on reading from the new response.
+type resumableBody struct {
+	c         *Client
+	req       *Request
+	body      io.ReadCloser
+	validator string // ETag or Last-Modified sent as If-Range
+	current   string // Url the body is being read from
+	offset    int64  // Bytes read so far
+	attempts  int    // Attempts made so far, reconnects included
+	err       error  // Error ending the download, returned by every later Read
+	closed    bool
+}
+
+// Download is like Do, for GET requests with large bodies. When reading the
+// body of a 200 response fails midway the request is sent again, to the next
+// target first, with a Range header asking for the rest of the body, and
+// reading carries on from the new response. The If-Range header, set to the
+// strong ETag or the Last-Modified date of the first response, makes sure the
+// rest belongs to the same version of the resource; responses without either
+// are not resumed.
+//
+// Reconnects go through the retry policy: CheckForRetry is handed the read
+// error, Backoff is waited before reconnecting, and the attempts made by the
+// reconnects are taken from the same RetryMax budget as the first Do.
+func (c *Client) Download(req *Request) (*http.Response, error) {
+	resp, err := c.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK || req.Method != http.MethodGet {
+		return resp, err
+	}
+
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	if validator == "" {
+		return resp, nil
+	}
+	resp.Body = &resumableBody{
+		c:         c,
+		req:       req,
+		body:      resp.Body,
+		validator: validator,
+		current:   req.URL.String(),
+		attempts:  req.Attempts(),
+	}
+	return resp, nil
+}
+
+func (b *resumableBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	for {
+		n, err := b.body.Read(p)
+		b.offset += int64(n)
+		if err == nil || err == io.EOF || b.closed {
+			return n, err
+		}
+		// A truncated body must not look like a complete one afterwards
+		if b.err = b.resume(err); b.err != nil {
+			return n, b.err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (b *resumableBody) Close() error {
+	b.closed = true
+	return b.body.Close()
+}
+
+// resume replaces the failed body with the rest of the resource, unless the
+// retry policy or budget says otherwise
+func (b *resumableBody) resume(readErr error) error {
+	b.body.Close()
+
+	ctx := b.req.Context()
+	retry, err := b.c.CheckForRetry(ctx, nil, readErr)
+	if !retry {
+		if err != nil {
+			return err
+		}
+		return readErr
+	}
+
+	left := b.c.RetryMax + 1 - b.attempts
+	if left <= 0 {
+		return fmt.Errorf("%s %s %w after %d attempts: %v", b.req.Method, b.current, ErrGiveUp, b.c.RetryMax, readErr)
+	}
+	wait := b.c.Backoff(b.c.RetryWaitMin, b.c.RetryWaitMax, b.attempts-1, nil)
+	mtype := "DEBUG"
+	msg := fmt.Sprintf("%s %s: resuming at byte %d in %s (%d left): ", b.req.Method, b.current, b.offset, wait, left)
+	b.c.Logger(b.req, mtype, msg, readErr)
+	timer := time.NewTimer(wait)
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	}
+
+	req, err := b.request(left - 1)
+	if err != nil {
+		return err
+	}
+	resp, err := b.c.Do(req)
+	b.attempts += req.Attempts()
+	if err != nil {
+		return err
+	}
+	b.current = req.URL.String()
+	if resp.StatusCode != http.StatusPartialContent ||
+		!strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", b.offset)) {
+		b.c.drainBody(resp.Body)
+		return fmt.Errorf("%s %s: %w: got %s", req.Method, b.current, ErrNotResumable, resp.Status)
+	}
+	b.body = resp.Body
+	return nil
+}
+
+// request returns the request for the rest of the resource, scheduled to the
+// target after the one which failed first
+func (b *resumableBody) request(retryMax int) (*Request, error) {
+	httpReq := b.req.Request.Clone(b.req.Context())
+	httpReq.Header.Set("Range", fmt.Sprintf("bytes=%d-", b.offset))
+	httpReq.Header.Set("If-Range", b.validator)
+
+	targets, err := b.c.resolve(b.req)
+	if err != nil {
+		return nil, err
+	}
+	for i, t := range targets {
+		if t.String() == b.current {
+			targets = rotate(targets, i+1)
+			break
+		}
+	}
+	return &Request{Request: httpReq, urls: targetURLs(targets), targets: targets, retryMax: &retryMax}, nil
+}
+
+// resolve returns the targets of req pointing at the url of the resource,
+// the client Targets joined with the path of relative requests
+func (c *Client) resolve(req *Request) ([]*Target, error) {
+	targets, _, ref, err := c.targets(req)
+	if err != nil || ref == nil {
+		return targets, err
+	}
+	joined := make([]*Target, len(targets))
+	for i, t := range targets {
+		tt := *t
+		tt.URL = joinURL(t.URL, ref)
+		joined[i] = &tt
+	}
+	return joined, nil
+}
+
+// rotate returns a copy of targets starting at the k-th one, wrapping around
+func rotate(targets []*Target, k int) []*Target {
+	k %= len(targets)
+	return append(append([]*Target{}, targets[k:]...), targets[:k]...)
+}
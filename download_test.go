@@ -0,0 +1,153 @@
+package retrigo
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rangeServer serves content with etag, recording the Range headers received
+func rangeServer(content []byte, etag string, ranges *[]string, mu *sync.Mutex) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		*ranges = append(*ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+func TestClient_Download(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	var mu sync.Mutex
+	var ranges1, ranges2 []string
+	ts1 := rangeServer(content, `"v1"`, &ranges1, &mu)
+	defer ts1.Close()
+	ts2 := rangeServer(content, `"v1"`, &ranges2, &mu)
+	defer ts2.Close()
+
+	client := NewClient()
+	client.RetryMax = 2
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.HTTPClient.Transport = NewFaultTransport(client.HTTPClient.Transport,
+		&FaultRule{Host: ts1.Listener.Addr().String(), Truncate: true, TruncateAfter: 3000})
+
+	req, err := NewRequest("GET", ts1.URL+" "+ts2.URL, nil)
+	checkErr(t, err, true)
+	resp, err := client.Download(req)
+	checkErr(t, err, true)
+	body, err := io.ReadAll(resp.Body)
+	checkErr(t, err, true)
+	resp.Body.Close()
+
+	if !bytes.Equal(body, content) {
+		t.Fatalf("bad body: got %d bytes", len(body))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ranges1) != 1 || ranges1[0] != "" || len(ranges2) != 1 || ranges2[0] != "bytes=3000-" {
+		t.Fatalf("bad ranges: %q %q", ranges1, ranges2)
+	}
+}
+
+func TestClient_Download_relative(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	var mu sync.Mutex
+	var ranges1, ranges2 []string
+	ts1 := rangeServer(content, `"v1"`, &ranges1, &mu)
+	defer ts1.Close()
+	ts2 := rangeServer(content, `"v1"`, &ranges2, &mu)
+	defer ts2.Close()
+
+	client := NewClient()
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.Targets = NewStaticTargets(URLTargets(mustParse(t, ts1.URL), mustParse(t, ts2.URL))...)
+	client.HTTPClient.Transport = NewFaultTransport(client.HTTPClient.Transport,
+		&FaultRule{Host: ts1.Listener.Addr().String(), Truncate: true, TruncateAfter: 3000})
+
+	// The rest comes from the target after the one which failed
+	req, err := NewRequest("GET", "/blob", nil)
+	checkErr(t, err, true)
+	resp, err := client.Download(req)
+	checkErr(t, err, true)
+	body, err := io.ReadAll(resp.Body)
+	checkErr(t, err, true)
+	resp.Body.Close()
+
+	if !bytes.Equal(body, content) {
+		t.Fatalf("bad body: got %d bytes", len(body))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ranges1) != 1 || len(ranges2) != 1 || ranges2[0] != "bytes=3000-" {
+		t.Fatalf("bad ranges: %q %q", ranges1, ranges2)
+	}
+}
+
+func TestClient_Download_changed(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 1000)
+	var mu sync.Mutex
+	var ranges1, ranges2 []string
+	ts1 := rangeServer(content, `"v1"`, &ranges1, &mu)
+	defer ts1.Close()
+	ts2 := rangeServer(content, `"v2"`, &ranges2, &mu)
+	defer ts2.Close()
+
+	client := NewClient()
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.HTTPClient.Transport = NewFaultTransport(client.HTTPClient.Transport,
+		&FaultRule{Host: ts1.Listener.Addr().String(), Truncate: true, TruncateAfter: 100})
+
+	req, err := NewRequest("GET", ts1.URL+" "+ts2.URL, nil)
+	checkErr(t, err, true)
+	resp, err := client.Download(req)
+	checkErr(t, err, true)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if !errors.Is(err, ErrNotResumable) || len(body) != 100 {
+		t.Fatalf("expected ErrNotResumable after 100 bytes, got %v after %d", err, len(body))
+	}
+	// and keeps failing rather than ending cleanly
+	if n, err := resp.Body.Read(make([]byte, 10)); n != 0 || !errors.Is(err, ErrNotResumable) {
+		t.Fatalf("expected ErrNotResumable again, got %d bytes and %v", n, err)
+	}
+}
+
+func TestClient_Download_budget(t *testing.T) {
+	content := []byte(strings.Repeat("y", 1000))
+	var mu sync.Mutex
+	var ranges []string
+	ts := rangeServer(content, `"v1"`, &ranges, &mu)
+	defer ts.Close()
+
+	client := NewClient()
+	client.RetryMax = 2
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.HTTPClient.Transport = NewFaultTransport(client.HTTPClient.Transport,
+		&FaultRule{Truncate: true, TruncateAfter: 10})
+
+	req, err := NewRequest("GET", ts.URL, nil)
+	checkErr(t, err, true)
+	resp, err := client.Download(req)
+	checkErr(t, err, true)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if !errors.Is(err, ErrGiveUp) || len(body) != 30 {
+		t.Fatalf("expected ErrGiveUp after 30 bytes, got %v after %d", err, len(body))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ranges) != 3 {
+		t.Fatalf("expected 3 attempts, got %q", ranges)
+	}
+}
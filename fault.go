@@ -0,0 +1,214 @@
+package retrigo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInjectedFault is the transport error returned by a FaultRule
+	// without Err
+	ErrInjectedFault = errors.New("injected fault: connection reset")
+)
+
+// FaultRule describes a fault FaultTransport injects into matching attempts.
+// The effects are applied in order: Latency is added, then the attempt hangs,
+// fails with a transport error or gets a canned status, whichever is set
+// first; otherwise it is sent and its response body is truncated if asked.
+type FaultRule struct {
+	Host        string  // Host (host:port) the rule applies to, empty for any
+	Probability float64 // Chance of a matching attempt being faulted, zero means always
+	Times       int     // Number of attempts to fault, zero means unlimited
+
+	Latency       time.Duration // Delay added before the attempt
+	Hang          bool          // Block until the request context is done
+	Err           error         // Fail with this transport error
+	ConnError     bool          // Fail with ErrInjectedFault
+	StatusCode    int           // Answer with this status and an empty body
+	Truncate      bool          // Cut the response body short
+	TruncateAfter int64         // Bytes of the body delivered before failing
+
+	applied int
+}
+
+// FaultRecord describes a faulted attempt
+type FaultRecord struct {
+	Attempt int        // Sequence number of the attempt on the transport, from zero
+	Method  string     // Request method
+	URL     string     // Request url
+	Rule    *FaultRule // Rule applied
+	Kind    string     // What was injected: latency, hang, error, status or truncate
+}
+
+// FaultTransport is an http.RoundTripper injecting faults into the attempts
+// sent through it, for testing how retries cope with failing targets. For
+// each attempt the first matching rule which triggers is applied, and the
+// faulted attempts are recorded.
+type FaultTransport struct {
+	Transport http.RoundTripper // Transport used to send attempts, http.DefaultTransport when nil
+	Rules     []*FaultRule      // Rules tried in order
+	Rand      *rand.Rand        // Source for probabilities, seeded from the clock when nil
+
+	mu       sync.Mutex
+	attempts int
+	faults   []FaultRecord
+}
+
+// NewFaultTransport creates a FaultTransport applying rules over rt
+func NewFaultTransport(rt http.RoundTripper, rules ...*FaultRule) *FaultTransport {
+	return &FaultTransport{
+		Transport: rt,
+		Rules:     rules,
+	}
+}
+
+// Faults returns the faulted attempts so far
+func (f *FaultTransport) Faults() []FaultRecord {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FaultRecord(nil), f.faults...)
+}
+
+// Attempts returns the number of attempts seen so far, faulted or not
+func (f *FaultTransport) Attempts() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.attempts
+}
+
+// pick returns the rule to apply to req, if any, and the attempt number
+func (f *FaultTransport) pick(req *http.Request) (*FaultRule, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	attempt := f.attempts
+	f.attempts++
+	if f.Rand == nil {
+		f.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	for _, rule := range f.Rules {
+		if rule.Host != "" && rule.Host != req.URL.Host {
+			continue
+		}
+		if rule.Times > 0 && rule.applied >= rule.Times {
+			continue
+		}
+		if rule.Probability > 0 && f.Rand.Float64() >= rule.Probability {
+			continue
+		}
+		rule.applied++
+		return rule, attempt
+	}
+	return nil, attempt
+}
+
+func (f *FaultTransport) record(req *http.Request, rule *FaultRule, attempt int, kind string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, FaultRecord{
+		Attempt: attempt,
+		Method:  req.Method,
+		URL:     req.URL.String(),
+		Rule:    rule,
+		Kind:    kind,
+	})
+}
+
+// RoundTrip sends req, injecting a fault if a rule triggers
+func (f *FaultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := f.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	rule, attempt := f.pick(req)
+	if rule == nil {
+		return rt.RoundTrip(req)
+	}
+
+	if rule.Latency > 0 {
+		f.record(req, rule, attempt, "latency")
+		timer := time.NewTimer(rule.Latency)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			closeRequestBody(req)
+			return nil, req.Context().Err()
+		}
+	}
+
+	switch {
+	case rule.Hang:
+		f.record(req, rule, attempt, "hang")
+		<-req.Context().Done()
+		closeRequestBody(req)
+		return nil, req.Context().Err()
+	case rule.Err != nil || rule.ConnError:
+		f.record(req, rule, attempt, "error")
+		closeRequestBody(req)
+		if rule.Err != nil {
+			return nil, rule.Err
+		}
+		return nil, ErrInjectedFault
+	case rule.StatusCode > 0:
+		f.record(req, rule, attempt, "status")
+		closeRequestBody(req)
+		return &http.Response{
+			Status:     fmt.Sprintf("%d %s", rule.StatusCode, http.StatusText(rule.StatusCode)),
+			StatusCode: rule.StatusCode,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{},
+			Body:       io.NopCloser(bytes.NewReader(nil)),
+			Request:    req,
+		}, nil
+	}
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil || !rule.Truncate {
+		return resp, err
+	}
+	f.record(req, rule, attempt, "truncate")
+	resp.Body = &truncatedBody{body: resp.Body, left: rule.TruncateAfter}
+	return resp, nil
+}
+
+// closeRequestBody closes the body of req, as a RoundTripper must even when
+// the request isn't sent
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// truncatedBody delivers left bytes of body and then fails as if the
+// connection dropped
+type truncatedBody struct {
+	body io.ReadCloser
+	left int64
+}
+
+func (t *truncatedBody) Read(p []byte) (int, error) {
+	if t.left <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if int64(len(p)) > t.left {
+		p = p[:t.left]
+	}
+	n, err := t.body.Read(p)
+	t.left -= int64(n)
+	return n, err
+}
+
+func (t *truncatedBody) Close() error {
+	return t.body.Close()
+}
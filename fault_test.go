@@ -0,0 +1,157 @@
+package retrigo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFaultTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello world"))
+	}))
+	defer ts.Close()
+	host := mustParse(t, ts.URL).Host
+
+	ft := NewFaultTransport(nil,
+		&FaultRule{Host: "127.0.0.1:1", StatusCode: 500},
+		&FaultRule{Host: host, Times: 1, ConnError: true},
+		&FaultRule{Host: host, Times: 1, Latency: 10 * time.Millisecond, StatusCode: 503},
+	)
+	client := NewClient()
+	client.HTTPClient.Transport = ft
+
+	start := time.Now()
+	resp, err := client.Get(ts.URL)
+	checkErr(t, err, true)
+	body, err := io.ReadAll(resp.Body)
+	checkErr(t, err, true)
+	resp.Body.Close()
+	if string(body) != "hello world" {
+		t.Fatalf("bad body: %s", body)
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatal("latency not injected")
+	}
+
+	// Assert exactly which attempts were faulted
+	faults := ft.Faults()
+	kinds := []string{"error", "latency", "status"}
+	if len(faults) != len(kinds) || ft.Attempts() != 3 {
+		t.Fatalf("bad faults: %#v (%d attempts)", faults, ft.Attempts())
+	}
+	for i, f := range faults {
+		if f.Kind != kinds[i] {
+			t.Fatalf("fault %d: expected %s, got %s", i, kinds[i], f.Kind)
+		}
+	}
+	if faults[0].Attempt != 0 || faults[1].Attempt != 1 || faults[2].Rule != ft.Rules[2] {
+		t.Fatalf("bad fault attempts: %#v", faults)
+	}
+
+	// Errors are the configured ones
+	ft.Rules = []*FaultRule{{Err: errors.New("boom")}}
+	client.CheckForRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		return false, nil
+	}
+	_, err = client.Get(ts.URL)
+	var uerr *url.Error
+	if !errors.As(err, &uerr) || uerr.Err.Error() != "boom" {
+		t.Fatalf("expected boom, got: %v", err)
+	}
+}
+
+func TestFaultTransport_truncateAndHang(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello world"))
+	}))
+	defer ts.Close()
+
+	ft := NewFaultTransport(nil, &FaultRule{Truncate: true, TruncateAfter: 5, Times: 1}, &FaultRule{Hang: true})
+	client := NewClient()
+	client.HTTPClient.Transport = ft
+
+	resp, err := client.Get(ts.URL)
+	checkErr(t, err, true)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hello" || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("bad truncated body: %q %v", body, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, err := NewRequest("GET", ts.URL, nil)
+	checkErr(t, err, true)
+	_, err = client.Do(req.WithContext(ctx))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got: %v", err)
+	}
+}
+
+// closeCounter counts the calls to Close
+type closeCounter struct {
+	io.Reader
+	closed int
+}
+
+func (c *closeCounter) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestFaultTransport_cancelClosesBody(t *testing.T) {
+	rules := map[string]*FaultRule{
+		"hang":    {Hang: true},
+		"latency": {Latency: time.Hour},
+	}
+	for name, rule := range rules {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		body := &closeCounter{Reader: strings.NewReader("payload")}
+		req, err := http.NewRequestWithContext(ctx, "POST", "http://example.invalid", body)
+		checkErr(t, err, true)
+		_, err = NewFaultTransport(nil, rule).RoundTrip(req)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("%s: expected deadline exceeded, got: %v", name, err)
+		}
+		if body.closed != 1 {
+			t.Fatalf("%s: body closed %d times", name, body.closed)
+		}
+	}
+}
+
+func TestFaultTransport_probability(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	ft := NewFaultTransport(nil, &FaultRule{Probability: 0.5, StatusCode: 502})
+	ft.Rand = rand.New(rand.NewSource(1))
+	client := NewClient()
+	client.HTTPClient.Transport = ft
+	client.CheckForRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		return false, nil
+	}
+
+	faulted := 0
+	for i := 0; i < 200; i++ {
+		resp, err := client.Get(ts.URL)
+		checkErr(t, err, true)
+		resp.Body.Close()
+		if resp.StatusCode == 502 {
+			faulted++
+		}
+	}
+	if faulted != len(ft.Faults()) || faulted < 70 || faulted > 130 {
+		t.Fatalf("bad fault rate: %d/200 (%d recorded)", faulted, len(ft.Faults()))
+	}
+}
@@ -0,0 +1,170 @@
+package retrigo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// DefaultMaxBodySize is the default limit of the response bodies decoded
+	// by the typed helpers
+	DefaultMaxBodySize int64 = 10 << 20
+
+	// ErrBodyTooLarge is returned, wrapped, when a response body is over the
+	// Client.MaxBodySize limit
+	ErrBodyTooLarge = errors.New("response body too large")
+)
+
+// StatusError is returned by the typed helpers when the final response has
+// a status other than 2xx
+type StatusError struct {
+	Method     string      // Request method
+	URL        string      // Url of the last attempt
+	StatusCode int         // Response status code
+	Status     string      // Response status
+	Header     http.Header // Response headers
+	Body       []byte      // Response body, up to Client.MaxBodySize
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Status)
+}
+
+// readBody reads and closes body, failing with ErrBodyTooLarge past limit
+func readBody(body io.ReadCloser, limit int64) ([]byte, error) {
+	defer body.Close()
+	buf, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > limit {
+		return nil, fmt.Errorf("%w, over %d bytes", ErrBodyTooLarge, limit)
+	}
+	return buf, nil
+}
+
+// responseCodec returns the codec for the Content-Type of resp, or else for
+// the first media type of the request Accept header with one
+func (c *Client) responseCodec(req *Request, resp *http.Response) (Codec, error) {
+	ct := resp.Header.Get("Content-Type")
+	if codec, err := c.Codec(ct); err == nil {
+		return codec, nil
+	}
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		if codec, err := c.Codec(strings.TrimSpace(accept)); err == nil {
+			return codec, nil
+		}
+	}
+	return nil, fmt.Errorf("%s %s: %w %q", req.Method, req.URL, ErrNoCodec, ct)
+}
+
+// decode sends req and decodes the response body into out with the codec
+// for its content type. With RetryDecodeErrors, bodies which can't be read
+// or decoded are retried within the RetryMax budget of the request.
+func (c *Client) decode(req *Request, out interface{}) error {
+	limit := c.MaxBodySize
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+
+	// The budget of each Do is narrowed as attempts are made, then the
+	// request is given back as it came
+	budget := c.RetryMax
+	if req.retryMax != nil {
+		budget = *req.retryMax
+	}
+	defer func(retryMax *int) { req.retryMax = retryMax }(req.retryMax)
+
+	attempts := 0
+	for {
+		retryMax := budget - attempts
+		req.retryMax = &retryMax
+		resp, err := c.Do(req)
+		attempts += req.Attempts()
+		if err != nil {
+			return err
+		}
+
+		body, err := readBody(resp.Body, limit)
+		switch {
+		case err != nil:
+			err = fmt.Errorf("%s %s: reading response: %w", req.Method, req.URL, err)
+		case resp.StatusCode < 200 || resp.StatusCode > 299:
+			return &StatusError{
+				Method:     req.Method,
+				URL:        req.URL.String(),
+				StatusCode: resp.StatusCode,
+				Status:     resp.Status,
+				Header:     resp.Header,
+				Body:       body,
+			}
+		case len(body) == 0 && (resp.StatusCode == http.StatusNoContent || req.Method == http.MethodHead):
+			return nil
+		default:
+			codec, cerr := c.responseCodec(req, resp)
+			if cerr != nil {
+				return cerr
+			}
+			if err = codec.Decode(bytes.NewReader(body), out); err == nil {
+				return nil
+			}
+			err = fmt.Errorf("%s %s: decoding response: %w", req.Method, req.URL, err)
+		}
+
+		if !c.RetryDecodeErrors || errors.Is(err, ErrBodyTooLarge) {
+			return err
+		}
+		if attempts > budget {
+			return fmt.Errorf("%s %s %w after %d attempts: %v", req.Method, req.URL, ErrGiveUp, budget, err)
+		}
+		wait := c.Backoff(c.RetryWaitMin, c.RetryWaitMax, attempts-1, resp)
+		mtype := "DEBUG"
+		msg := fmt.Sprintf("%s %s: retrying in %s (%d left): ", req.Method, req.URL, wait, budget+1-attempts)
+		c.Logger(req, mtype, msg, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return req.Context().Err()
+		}
+	}
+}
+
+// DoAs sends req with c and decodes the response into a T, with the codec
+// for the response Content-Type or else for the request Accept header.
+// Responses other than 2xx fail with a *StatusError.
+func DoAs[T any](c *Client, req *Request) (T, error) {
+	var out T
+	err := c.decode(req, &out)
+	return out, err
+}
+
+// GetJSON sends a GET request to url with c and decodes the JSON response
+// into a T. Responses other than 2xx fail with a *StatusError.
+func GetJSON[T any](c *Client, url string) (T, error) {
+	req, err := NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		var out T
+		return out, err
+	}
+	req.Header.Set("Accept", "application/json")
+	return DoAs[T](c, req)
+}
+
+// DoJSON sends a request to url with c, with body encoded as JSON, and
+// decodes the JSON response into a Resp. Responses other than 2xx fail with
+// a *StatusError.
+func DoJSON[Req, Resp any](c *Client, method, url string, body Req) (Resp, error) {
+	req, err := c.NewEncodedRequest(method, url, "application/json", body)
+	if err != nil {
+		var out Resp
+		return out, err
+	}
+	return DoAs[Resp](c, req)
+}
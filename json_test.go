@@ -0,0 +1,130 @@
+package retrigo
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type item struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestGetJSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/json" {
+			t.Errorf("bad Accept: %s", r.Header.Get("Accept"))
+		}
+		switch r.URL.Path {
+		case "/item":
+			w.Write([]byte(`{"name":"a","count":3}`))
+		case "/missing":
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":"not found"}`))
+		case "/big":
+			w.Write([]byte(`"` + strings.Repeat("x", 100) + `"`))
+		}
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	got, err := GetJSON[item](client, ts.URL+"/item")
+	checkErr(t, err, true)
+	if got.Name != "a" || got.Count != 3 {
+		t.Fatalf("bad item: %+v", got)
+	}
+
+	_, err = GetJSON[item](client, ts.URL+"/missing")
+	var serr *StatusError
+	if !errors.As(err, &serr) || serr.StatusCode != 404 || string(serr.Body) != `{"error":"not found"}` {
+		t.Fatalf("expected a 404 StatusError, got %v", err)
+	}
+
+	client.MaxBodySize = 50
+	if _, err = GetJSON[string](client, ts.URL+"/big"); !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("expected ErrBodyTooLarge, got %v", err)
+	}
+}
+
+func TestDoJSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("bad Content-Type: %s", r.Header.Get("Content-Type"))
+		}
+		var in item
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			t.Errorf("bad body: %v", err)
+		}
+		in.Count++
+		json.NewEncoder(w).Encode(in)
+	}))
+	defer ts.Close()
+
+	got, err := DoJSON[item, item](NewClient(), "POST", ts.URL, item{Name: "b", Count: 1})
+	checkErr(t, err, true)
+	if got.Name != "b" || got.Count != 2 {
+		t.Fatalf("bad item: %+v", got)
+	}
+}
+
+func TestDoJSON_retryDecodeErrors(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"name":"c","count":0}` {
+			t.Errorf("bad body: %s", body)
+		}
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.Write([]byte(`{"name":"c","cou`))
+			return
+		}
+		w.Write([]byte(`{"name":"c","count":1}`))
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	var syntax *json.SyntaxError
+	if _, err := DoJSON[item, item](client, "PUT", ts.URL, item{Name: "c"}); !errors.As(err, &syntax) {
+		t.Fatalf("expected a decoding error, got %v", err)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Fatalf("decoding error retried: %d attempts", n)
+	}
+	atomic.StoreInt32(&attempts, 0)
+
+	client.RetryDecodeErrors = true
+	got, err := DoJSON[item, item](client, "PUT", ts.URL, item{Name: "c"})
+	checkErr(t, err, true)
+	if got.Count != 1 || atomic.LoadInt32(&attempts) != 3 {
+		t.Fatalf("bad item %+v after %d attempts", got, attempts)
+	}
+
+	atomic.StoreInt32(&attempts, 0)
+	client.RetryMax = 1
+	if _, err = DoJSON[item, item](client, "PUT", ts.URL, item{Name: "c"}); !errors.Is(err, ErrGiveUp) {
+		t.Fatalf("expected ErrGiveUp, got %v", err)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 2 {
+		t.Fatalf("expected 2 attempts, got %d", n)
+	}
+
+	// The request keeps its own budget once decoded
+	atomic.StoreInt32(&attempts, 0)
+	client.RetryMax = 4
+	req, err := client.NewEncodedRequest("PUT", ts.URL, "application/json", item{Name: "c"})
+	checkErr(t, err, true)
+	_, err = DoAs[item](client, req)
+	checkErr(t, err, true)
+	if req.retryMax != nil {
+		t.Fatalf("retry budget left on the request: %d", *req.retryMax)
+	}
+}
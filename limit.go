@@ -0,0 +1,196 @@
+package retrigo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+)
+
+var (
+	// DefaultBackoffRatio is the default factor an AdaptiveLimit is multiplied
+	// by when a target shows signs of overload
+	DefaultBackoffRatio = 0.9
+)
+
+// Limiter bounds the number of attempts in flight. Client.Do takes a slot
+// before each attempt and releases it once the response headers arrive.
+type Limiter interface {
+	// TryAcquire takes a slot for an attempt to target if one is free
+	TryAcquire(target string) bool
+	// Acquire waits for a free slot for an attempt to target, or until ctx
+	// is done
+	Acquire(ctx context.Context, target string) error
+	// Release frees the slot taken for an attempt to target, r and err being
+	// the outcome of the attempt, both nil when it wasn't sent after all
+	Release(target string, r *http.Response, err error)
+}
+
+// AdaptiveLimit configures a ConcurrencyLimiter to adjust the per target limit
+// with an additive increase/multiplicative decrease (AIMD) algorithm, like
+// Netflix's concurrency-limits: the limit is multiplied by BackoffRatio on
+// timeouts and 503s, and raised by one on successes while the target is
+// using at least half of it.
+type AdaptiveLimit struct {
+	Initial      int     // Starting limit of every target
+	Min          int     // Lowest the limit can get
+	Max          int     // Highest the limit can get
+	BackoffRatio float64 // Factor applied on overload, DefaultBackoffRatio when zero
+}
+
+// ConcurrencyLimiter is a Limiter bounding the attempts in flight per client
+// and per target, targets being told apart by the scheme and host of their
+// url. A zero limit means unlimited. It must not be copied after first use.
+type ConcurrencyLimiter struct {
+	MaxInFlight          int            // Limit of attempts in flight across all targets
+	MaxInFlightPerTarget int            // Limit of attempts in flight per target
+	Adaptive             *AdaptiveLimit // Adaptive per target limit, overrides MaxInFlightPerTarget
+
+	mu      sync.Mutex
+	total   int
+	targets map[string]*targetLimit
+	changed chan struct{}
+}
+
+type targetLimit struct {
+	inflight int
+	limit    float64
+}
+
+// NewConcurrencyLimiter creates a ConcurrencyLimiter with fixed limits
+func NewConcurrencyLimiter(perClient, perTarget int) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		MaxInFlight:          perClient,
+		MaxInFlightPerTarget: perTarget,
+	}
+}
+
+// NewAdaptiveLimiter creates a ConcurrencyLimiter whose per target limit
+// starts at initial and adapts between min and max
+func NewAdaptiveLimiter(perClient, initial, min, max int) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		MaxInFlight: perClient,
+		Adaptive: &AdaptiveLimit{
+			Initial:      initial,
+			Min:          min,
+			Max:          max,
+			BackoffRatio: DefaultBackoffRatio,
+		},
+	}
+}
+
+func (l *ConcurrencyLimiter) target(target string) *targetLimit {
+	if l.targets == nil {
+		l.targets = make(map[string]*targetLimit)
+	}
+	target = targetKey(target)
+	tl, ok := l.targets[target]
+	if !ok {
+		tl = &targetLimit{limit: float64(l.MaxInFlightPerTarget)}
+		if l.Adaptive != nil {
+			tl.limit = float64(l.Adaptive.Initial)
+		}
+		l.targets[target] = tl
+	}
+	return tl
+}
+
+func (l *ConcurrencyLimiter) tryAcquire(target string) bool {
+	if l.MaxInFlight > 0 && l.total >= l.MaxInFlight {
+		return false
+	}
+	tl := l.target(target)
+	if tl.limit > 0 && tl.inflight >= int(tl.limit) {
+		return false
+	}
+	l.total++
+	tl.inflight++
+	return true
+}
+
+// TryAcquire takes a slot for an attempt to target if one is free
+func (l *ConcurrencyLimiter) TryAcquire(target string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.tryAcquire(target)
+}
+
+// Acquire waits for a free slot for an attempt to target, or until ctx is done
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context, target string) error {
+	for {
+		l.mu.Lock()
+		if l.tryAcquire(target) {
+			l.mu.Unlock()
+			return nil
+		}
+		if l.changed == nil {
+			l.changed = make(chan struct{})
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Release frees the slot taken for an attempt to target and, when adaptive,
+// adjusts the target limit according to the attempt outcome
+func (l *ConcurrencyLimiter) Release(target string, r *http.Response, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tl := l.target(target)
+	if a := l.Adaptive; a != nil {
+		switch {
+		case overloaded(r, err):
+			ratio := a.BackoffRatio
+			if ratio <= 0 {
+				ratio = DefaultBackoffRatio
+			}
+			tl.limit *= ratio
+		case r != nil && r.StatusCode < 500 && float64(tl.inflight*2) >= tl.limit:
+			tl.limit++
+		}
+		if tl.limit < float64(a.Min) {
+			tl.limit = float64(a.Min)
+		}
+		if a.Max > 0 && tl.limit > float64(a.Max) {
+			tl.limit = float64(a.Max)
+		}
+		if tl.limit < 1 {
+			tl.limit = 1
+		}
+	}
+
+	if tl.inflight > 0 {
+		tl.inflight--
+		l.total--
+	}
+	if l.changed != nil {
+		close(l.changed)
+		l.changed = nil
+	}
+}
+
+// Limit returns the current limit of attempts in flight to target, zero
+// meaning unlimited
+func (l *ConcurrencyLimiter) Limit(target string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.target(target).limit)
+}
+
+// overloaded reports whether an attempt outcome is a sign the target is
+// overloaded: a timeout or a 503 response.
+func overloaded(r *http.Response, err error) bool {
+	if err != nil {
+		var nerr net.Error
+		return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &nerr) && nerr.Timeout())
+	}
+	return r != nil && r.StatusCode == http.StatusServiceUnavailable
+}
@@ -0,0 +1,168 @@
+package retrigo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	l := NewConcurrencyLimiter(3, 2)
+
+	// Per target limit
+	if !l.TryAcquire("a") || !l.TryAcquire("a") {
+		t.Fatal("should acquire")
+	}
+	if l.TryAcquire("a") {
+		t.Fatal("per target limit not enforced")
+	}
+
+	// Per client limit
+	if !l.TryAcquire("b") {
+		t.Fatal("should acquire")
+	}
+	if l.TryAcquire("c") {
+		t.Fatal("per client limit not enforced")
+	}
+
+	// Acquire honours the context
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Acquire(ctx, "c"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got: %v", err)
+	}
+
+	// and is woken up by Release
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- l.Acquire(context.Background(), "c")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	l.Release("a", nil, nil)
+	select {
+	case err := <-doneCh:
+		checkErr(t, err, true)
+	case <-time.After(time.Second):
+		t.Fatal("Acquire was not woken up")
+	}
+}
+
+func TestConcurrencyLimiter_paths(t *testing.T) {
+	l := NewConcurrencyLimiter(0, 1)
+
+	// Paths and queries share the slots of their target
+	if !l.TryAcquire("http://a/x") {
+		t.Fatal("should acquire")
+	}
+	if l.TryAcquire("http://a/y?z=1") {
+		t.Fatal("per target limit not enforced across paths")
+	}
+	if !l.TryAcquire("http://b/x") || !l.TryAcquire("https://a/x") {
+		t.Fatal("should acquire")
+	}
+	l.Release("http://a/z", nil, nil)
+	if !l.TryAcquire("http://a/y") {
+		t.Fatal("slot not released")
+	}
+	if n := len(l.targets); n != 3 {
+		t.Fatalf("expected 3 targets tracked, got %d", n)
+	}
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	l := NewAdaptiveLimiter(0, 10, 2, 12)
+	ok := &http.Response{StatusCode: 200}
+	unavailable := &http.Response{StatusCode: 503}
+
+	if v := l.Limit("a"); v != 10 {
+		t.Fatalf("bad initial limit: %d", v)
+	}
+
+	// Decreases on 503s and timeouts, down to Min
+	l.TryAcquire("a")
+	l.Release("a", unavailable, nil)
+	if v := l.Limit("a"); v != 9 {
+		t.Fatalf("bad limit after 503: %d", v)
+	}
+	for i := 0; i < 50; i++ {
+		l.TryAcquire("a")
+		l.Release("a", nil, context.DeadlineExceeded)
+	}
+	if v := l.Limit("a"); v != 2 {
+		t.Fatalf("bad limit after timeouts: %d", v)
+	}
+
+	// Increases on successes while in use, up to Max
+	for i := 0; i < 50; i++ {
+		n := 0
+		for l.TryAcquire("a") {
+			n++
+		}
+		for k := 0; k < n; k++ {
+			l.Release("a", ok, nil)
+		}
+	}
+	if v := l.Limit("a"); v != 12 {
+		t.Fatalf("bad limit after successes: %d", v)
+	}
+}
+
+func TestClient_Do_Limiter(t *testing.T) {
+	var hits1, hits2 int32
+	block := make(chan struct{})
+	ts1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits1, 1)
+		<-block
+		w.WriteHeader(200)
+	}))
+	defer ts1.Close()
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits2, 1)
+		<-block
+		w.WriteHeader(200)
+	}))
+	defer ts2.Close()
+
+	client := NewClient()
+	client.Limiter = NewConcurrencyLimiter(0, 1)
+	durl := ts1.URL + " " + ts2.URL
+
+	// The second request moves to the free target
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := NewRequest("GET", durl, nil)
+			checkErr(t, err, true)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Errorf("err: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+		time.Sleep(50 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&hits1) != 1 || atomic.LoadInt32(&hits2) != 1 {
+		t.Fatalf("bad spread: %d/%d", hits1, hits2)
+	}
+
+	// A third one waits for a slot until its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := NewRequest("GET", durl, nil)
+	checkErr(t, err, true)
+	_, err = client.Do(req.WithContext(ctx))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got: %v", err)
+	}
+
+	close(block)
+	wg.Wait()
+}
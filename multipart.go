@@ -0,0 +1,138 @@
+package retrigo
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// FormFile is a file part of a multipart form
+type FormFile struct {
+	Field       string     // Form field name
+	Filename    string     // File name sent along the content
+	ContentType string     // Content type of the part, application/octet-stream when empty
+	Open        ReaderFunc // Opens the content, called again on every attempt
+	Size        int64      // Size of the content when known, zero meaning unknown
+}
+
+// countWriter counts the bytes written to it
+type countWriter int64
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	*c += countWriter(len(p))
+	return len(p), nil
+}
+
+// quoteEscaper escapes a file name for the Content-Disposition header, as
+// mime/multipart does
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// writeMultipart writes fields, in key order, and files to mw. When open is
+// false the file contents are left out.
+func writeMultipart(mw *multipart.Writer, fields url.Values, files []*FormFile, open bool) error {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range fields[k] {
+			if err := mw.WriteField(k, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, f := range files {
+		ct := f.ContentType
+		if ct == "" {
+			ct = "application/octet-stream"
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			quoteEscaper.Replace(f.Field), quoteEscaper.Replace(f.Filename)))
+		h.Set("Content-Type", ct)
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		if !open {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("opening %s: %w", f.Filename, err)
+		}
+		_, err = io.Copy(part, r)
+		closeReader(r)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", f.Filename, err)
+		}
+	}
+	return mw.Close()
+}
+
+// NewMultipartRequest creates a wrapped request whose body is a
+// multipart/form-data form made of fields and files. The form is streamed,
+// and built again with the same boundary on every attempt, reopening the
+// files. The Content-Length is set when the Size of every file is known.
+func NewMultipartRequest(method, durl string, fields url.Values, files ...*FormFile) (*Request, error) {
+	for _, f := range files {
+		if f.Open == nil {
+			return nil, fmt.Errorf("form file %s: no Open function", f.Field)
+		}
+	}
+	req, err := NewRequest(method, durl, nil)
+	if err != nil {
+		return nil, err
+	}
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+
+	var length countWriter
+	mw := multipart.NewWriter(&length)
+	mw.SetBoundary(boundary)
+	if err := writeMultipart(mw, fields, files, false); err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.Size <= 0 {
+			length = 0
+			break
+		}
+		length += countWriter(f.Size)
+	}
+
+	req.body = func() (io.Reader, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			mw := multipart.NewWriter(pw)
+			mw.SetBoundary(boundary)
+			pw.CloseWithError(writeMultipart(mw, fields, files, true))
+		}()
+		return pr, nil
+	}
+	req.ContentLength = int64(length)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req, nil
+}
+
+// PostMultipart is a shortcut to perform a POST with a multipart form without
+// creating a new client.
+func PostMultipart(url string, fields url.Values, files ...*FormFile) (*http.Response, error) {
+	return defaultClient.PostMultipart(url, fields, files...)
+}
+
+// PostMultipart is a convenience method for doing POST operations with a
+// multipart/form-data form, see NewMultipartRequest.
+func (c *Client) PostMultipart(url string, fields url.Values, files ...*FormFile) (*http.Response, error) {
+	req, err := NewMultipartRequest(http.MethodPost, url, fields, files...)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
@@ -0,0 +1,148 @@
+package retrigo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClient_PostMultipart(t *testing.T) {
+	var mu sync.Mutex
+	var bodies [][]byte
+	var lengths []int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, body)
+		lengths = append(lengths, r.ContentLength)
+		n := len(bodies)
+		mu.Unlock()
+		if n == 1 {
+			w.WriteHeader(503)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("bad form: %v", err)
+			return
+		}
+		if r.FormValue("name") != "report" {
+			t.Errorf("bad field: %q", r.FormValue("name"))
+		}
+		f, hdr, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("missing file: %v", err)
+			return
+		}
+		content, _ := io.ReadAll(f)
+		if string(content) != "file content" || hdr.Filename != "a.txt" || hdr.Header.Get("Content-Type") != "text/plain" {
+			t.Errorf("bad file part: %q %q %q", content, hdr.Filename, hdr.Header.Get("Content-Type"))
+		}
+	}))
+	defer ts.Close()
+
+	opened := 0
+	file := &FormFile{
+		Field:       "file",
+		Filename:    "a.txt",
+		ContentType: "text/plain",
+		Size:        12,
+		Open: func() (io.Reader, error) {
+			opened++
+			return strings.NewReader("file content"), nil
+		},
+	}
+
+	client := NewClient()
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	resp, err := client.PostMultipart(ts.URL, url.Values{"name": {"report"}}, file)
+	checkErr(t, err, true)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("bad status: %d", resp.StatusCode)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 2 || opened != 2 {
+		t.Fatalf("expected 2 attempts opening the file, got %d and %d", len(bodies), opened)
+	}
+	if !bytes.Equal(bodies[0], bodies[1]) {
+		t.Fatal("attempts sent different bodies")
+	}
+	if lengths[0] != int64(len(bodies[0])) {
+		t.Fatalf("bad Content-Length: %d for %d bytes", lengths[0], len(bodies[0]))
+	}
+}
+
+func TestNewMultipartRequest_unknownSize(t *testing.T) {
+	var length int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		length = r.ContentLength
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("bad form: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	req, err := NewMultipartRequest("PUT", ts.URL, nil, &FormFile{
+		Field: "file",
+		Open: func() (io.Reader, error) {
+			return strings.NewReader("data"), nil
+		},
+	})
+	checkErr(t, err, true)
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data; boundary=") {
+		t.Fatalf("bad content type: %s", req.Header.Get("Content-Type"))
+	}
+
+	resp, err := NewClient().Do(req)
+	checkErr(t, err, true)
+	resp.Body.Close()
+	if length != -1 {
+		t.Fatalf("expected a chunked body, got Content-Length %d", length)
+	}
+
+	if _, err := NewMultipartRequest("POST", ts.URL, nil, &FormFile{Field: "file"}); err == nil {
+		t.Fatal("expected error for a file without Open")
+	}
+}
+
+func TestClient_PostMultipart_unsent(t *testing.T) {
+	// The client is over its rate, so the attempt is never sent
+	limiter := NewTokenBucketLimiter(Rate{Limit: 1e-3, Burst: 1}, Rate{})
+	limiter.FailFast = true
+	checkErr(t, limiter.Wait(context.Background(), "http://localhost"), true)
+	client := NewClient()
+	client.RateLimiter = limiter
+
+	before := runtime.NumGoroutine()
+	_, err := client.PostMultipart("http://localhost", url.Values{"k": {"v"}}, &FormFile{
+		Field:    "file",
+		Filename: "big.bin",
+		Open: func() (io.Reader, error) {
+			return strings.NewReader("data"), nil
+		},
+	})
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	// The form writer must not be left blocked on the unread body
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			t.Fatalf("form writer left running: %d goroutines, %d before", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,181 @@
+package retrigo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+var (
+	// ErrNoQuorum is returned by DoQuorum, wrapped, when fewer targets than
+	// required acknowledged the request
+	ErrNoQuorum = errors.New("quorum not reached")
+
+	// ErrStraggler is the error of the targets DoQuorum didn't wait for
+	// because the quorum was reached first
+	ErrStraggler = errors.New("left running after quorum")
+)
+
+// TargetResult is the outcome of the request sent to one target
+type TargetResult struct {
+	Target   *Target        // The target
+	Response *http.Response // The response, its body must be closed by the caller
+	Err      error          // The error returned by Do
+	Attempts int            // Number of attempts made to the target
+	OK       bool           // Whether the target succeeded, as judged by QuorumOptions.Success for DoQuorum
+}
+
+// QuorumOptions configures DoQuorum
+type QuorumOptions struct {
+	W int // Acknowledgements needed, a majority of the targets when zero
+
+	// Success reports whether r, received from t, acknowledges the request.
+	// Any 2xx response does when nil.
+	Success func(t *Target, r *http.Response) bool
+
+	// LeaveStragglers lets the requests still running once the quorum is
+	// reached finish in the background, their responses discarded, instead
+	// of cancelling them.
+	LeaveStragglers bool
+}
+
+// QuorumResult is the outcome of DoQuorum
+type QuorumResult struct {
+	Acks    int            // Number of targets which acknowledged the request
+	Results []TargetResult // One result per target, in the order of the targets
+}
+
+// isSuccess is the default QuorumOptions.Success
+func isSuccess(t *Target, r *http.Response) bool {
+	return r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// split returns a copy of req for each of its targets, each scheduled to that
+// target alone and sharing the header, context and body of req. The body is
+// read once so the copies can be sent concurrently.
+func (c *Client) split(req *Request) ([]*Target, []*Request, error) {
+	targets, _, ref, err := c.targets(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body := req.body
+	if body != nil {
+		r, err := body()
+		if err != nil {
+			return nil, nil, err
+		}
+		buf, err := io.ReadAll(r)
+		closeReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		body = func() (io.Reader, error) {
+			return bytes.NewReader(buf), nil
+		}
+	}
+
+	reqs := make([]*Request, len(targets))
+	for i, t := range targets {
+		if ref != nil {
+			joined := *t
+			joined.URL = joinURL(t.URL, ref)
+			t = &joined
+		}
+		httpReq := req.Request.Clone(req.Context())
+		u := *t.URL
+		httpReq.URL = &u
+		reqs[i] = &Request{body: body, Request: httpReq, urls: []string{t.String()}, targets: []*Target{t}}
+	}
+	return targets, reqs, nil
+}
+
+// DoQuorum sends req to every one of its targets concurrently, each with its
+// own retries and backoff, and succeeds once opts.W of them acknowledged it.
+// The requests still running at that point are cancelled, or left to finish
+// when opts.LeaveStragglers is set, in which case their results carry
+// ErrStraggler. When the quorum isn't reached the error wraps ErrNoQuorum and
+// the result still holds every target's response or error.
+func (c *Client) DoQuorum(req *Request, opts *QuorumOptions) (*QuorumResult, error) {
+	if opts == nil {
+		opts = &QuorumOptions{}
+	}
+	success := opts.Success
+	if success == nil {
+		success = isSuccess
+	}
+
+	targets, reqs, err := c.split(req)
+	if err != nil {
+		return nil, err
+	}
+	w := opts.W
+	if w <= 0 {
+		w = len(targets)/2 + 1
+	}
+	if w > len(targets) {
+		return nil, fmt.Errorf("quorum of %d with %d targets", w, len(targets))
+	}
+
+	type done struct {
+		i int
+		TargetResult
+	}
+	// buffered so stragglers never block once nobody is listening
+	results := make(chan done, len(reqs))
+	stopped := make(chan struct{})
+	for i := range reqs {
+		go func(i int) {
+			r := done{i: i, TargetResult: TargetResult{Target: targets[i]}}
+			r.Response, r.Err = c.doUntil(req.Context(), stopped, reqs[i])
+			r.Attempts = reqs[i].Attempts()
+			r.OK = r.Err == nil && r.Response != nil && success(targets[i], r.Response)
+			results <- r
+		}(i)
+	}
+
+	res := &QuorumResult{Results: make([]TargetResult, len(reqs))}
+	received := make([]bool, len(reqs))
+	n := 0
+	for ; n < len(reqs); n++ {
+		if res.Acks >= w {
+			if opts.LeaveStragglers {
+				break
+			}
+			select {
+			case <-stopped:
+			default:
+				close(stopped)
+			}
+		}
+		r := <-results
+		res.Results[r.i] = r.TargetResult
+		received[r.i] = true
+		if r.OK {
+			res.Acks++
+		}
+	}
+
+	if n < len(reqs) {
+		for i := range received {
+			if !received[i] {
+				res.Results[i] = TargetResult{Target: targets[i], Err: ErrStraggler}
+			}
+		}
+		go func() {
+			for ; n < len(reqs); n++ {
+				if r := <-results; r.Response != nil {
+					c.drainBody(r.Response.Body)
+				}
+			}
+		}()
+	}
+
+	if res.Acks < w {
+		return res, fmt.Errorf("%s %s: %w, %d of %d targets acknowledged, %d needed",
+			req.Method, req.URL, ErrNoQuorum, res.Acks, len(targets), w)
+	}
+	return res, nil
+}
@@ -0,0 +1,157 @@
+package retrigo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_DoQuorum(t *testing.T) {
+	var bodies int32
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) == "hello" {
+			atomic.AddInt32(&bodies, 1)
+		}
+		w.WriteHeader(201)
+	}))
+	defer ok.Close()
+	var failing int32
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&failing, 1) < 3 {
+			w.WriteHeader(503)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer bad.Close()
+
+	client := NewClient()
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	req, err := NewRequest("PUT", ok.URL+"/k "+bad.URL+"/k "+ok.URL+"/k", strings.NewReader("hello"))
+	checkErr(t, err, true)
+
+	res, err := client.DoQuorum(req, &QuorumOptions{W: 3})
+	checkErr(t, err, true)
+	if res.Acks != 3 || len(res.Results) != 3 {
+		t.Fatalf("bad result: %+v", res)
+	}
+	for i, r := range res.Results {
+		if !r.OK || r.Target != req.Targets()[i] {
+			t.Fatalf("result %d: %+v", i, r)
+		}
+		r.Response.Body.Close()
+	}
+	if res.Results[1].Attempts != 3 || res.Results[0].Attempts != 1 {
+		t.Fatalf("bad attempts: %d %d", res.Results[0].Attempts, res.Results[1].Attempts)
+	}
+	if n := atomic.LoadInt32(&bodies); n != 2 {
+		t.Fatalf("body not sent to every target: %d", n)
+	}
+}
+
+func TestClient_DoQuorum_noQuorum(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+	conflict := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(409)
+	}))
+	defer conflict.Close()
+
+	client := NewClient()
+	req, err := NewRequest("PUT", ok.URL+" "+conflict.URL+" "+conflict.URL, []byte("v"))
+	checkErr(t, err, true)
+
+	res, err := client.DoQuorum(req, nil)
+	if !errors.Is(err, ErrNoQuorum) {
+		t.Fatalf("expected ErrNoQuorum, got %v", err)
+	}
+	if res.Acks != 1 || res.Results[1].OK || res.Results[1].Response.StatusCode != 409 {
+		t.Fatalf("bad result: %+v", res)
+	}
+	for _, r := range res.Results {
+		r.Response.Body.Close()
+	}
+
+	// A custom predicate accepts conflicts as acknowledgements
+	res, err = client.DoQuorum(req, &QuorumOptions{
+		Success: func(t *Target, r *http.Response) bool {
+			return r.StatusCode < 300 || r.StatusCode == 409
+		},
+	})
+	checkErr(t, err, true)
+	if res.Acks < 2 {
+		t.Fatalf("bad acks: %d", res.Acks)
+	}
+	for _, r := range res.Results {
+		if r.Response != nil {
+			r.Response.Body.Close()
+		}
+	}
+
+	if _, err := client.DoQuorum(req, &QuorumOptions{W: 4}); err == nil {
+		t.Fatal("expected error for W above the number of targets")
+	}
+}
+
+func TestClient_DoQuorum_stragglers(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	client := NewClient()
+	req, err := NewRequest("PUT", ok.URL+" "+ok.URL+" "+slow.URL, []byte("v"))
+	checkErr(t, err, true)
+
+	res, err := client.DoQuorum(req, nil)
+	checkErr(t, err, true)
+	if res.Acks != 2 || !errors.Is(res.Results[2].Err, context.Canceled) {
+		t.Fatalf("straggler not cancelled: %+v", res.Results[2])
+	}
+
+	res, err = client.DoQuorum(req, &QuorumOptions{LeaveStragglers: true})
+	checkErr(t, err, true)
+	if res.Acks != 2 || res.Results[2].Err != ErrStraggler || res.Results[2].Target != req.Targets()[2] {
+		t.Fatalf("straggler not left running: %+v", res.Results[2])
+	}
+}
+
+func TestClient_DoQuorum_backoff(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer unavailable.Close()
+
+	client := NewClient()
+	client.RetryWaitMin = 3 * time.Second
+	client.RetryWaitMax = 3 * time.Second
+	req, err := NewRequest("PUT", unavailable.URL+" "+ok.URL+" "+ok.URL, []byte("v"))
+	checkErr(t, err, true)
+
+	start := time.Now()
+	res, err := client.DoQuorum(req, nil)
+	checkErr(t, err, true)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("straggler in backoff held the quorum for %s", d)
+	}
+	if res.Acks != 2 || !errors.Is(res.Results[0].Err, context.Canceled) {
+		t.Fatalf("straggler not cancelled: %+v", res.Results[0])
+	}
+}
@@ -0,0 +1,202 @@
+package retrigo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRateLimited is returned by a fail fast RateLimiter when an attempt
+	// isn't allowed right away
+	ErrRateLimited = errors.New("rate limited")
+)
+
+// RateLimiter paces attempts. Client.Do calls Wait before every attempt,
+// retries included, and Observe with the outcome of each of them.
+type RateLimiter interface {
+	// Wait blocks until an attempt to target is allowed, or ctx is done
+	Wait(ctx context.Context, target string) error
+	// Observe is called with the outcome of an attempt to target
+	Observe(target string, r *http.Response, err error)
+}
+
+// Rate is a token bucket rate: Limit tokens per second are added to a bucket
+// holding up to Burst tokens. A zero Limit means unlimited.
+type Rate struct {
+	Limit float64 // Tokens per second
+	Burst int     // Bucket size, at least 1
+}
+
+// TokenBucketLimiter is a RateLimiter with a token bucket shared by all
+// targets and one bucket per target, targets being told apart by the scheme
+// and host of their url. When a target answers 429 with a Retry-After header
+// no attempt is sent to it until then, and its bucket is emptied so it
+// doesn't resume with a burst. It must not be copied after first use.
+type TokenBucketLimiter struct {
+	PerClient Rate // Rate across all targets
+	PerTarget Rate // Rate of each target
+	FailFast  bool // Return ErrRateLimited instead of waiting
+
+	// TargetRates are the rates of specific targets, overriding PerTarget,
+	// keyed by scheme and host such as "https://api.example.com:8443"
+	TargetRates map[string]Rate
+
+	mu      sync.Mutex
+	client  *bucket
+	targets map[string]*bucket
+}
+
+type bucket struct {
+	rate   Rate
+	tokens float64
+	last   time.Time
+	hold   time.Time // no tokens are handed out before hold
+}
+
+// NewTokenBucketLimiter creates a blocking TokenBucketLimiter
+func NewTokenBucketLimiter(perClient, perTarget Rate) *TokenBucketLimiter {
+	return &TokenBucketLimiter{
+		PerClient: perClient,
+		PerTarget: perTarget,
+	}
+}
+
+func newBucket(rate Rate, now time.Time) *bucket {
+	if rate.Burst < 1 {
+		rate.Burst = 1
+	}
+	return &bucket{rate: rate, tokens: float64(rate.Burst), last: now}
+}
+
+// delay refills the bucket and returns how long until a token is available
+func (b *bucket) delay(now time.Time) time.Duration {
+	if now.Before(b.hold) {
+		return b.hold.Sub(now)
+	}
+	if b.rate.Limit <= 0 {
+		return 0
+	}
+	if b.last.Before(b.hold) {
+		b.last = b.hold
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate.Limit
+	if b.tokens > float64(b.rate.Burst) {
+		b.tokens = float64(b.rate.Burst)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate.Limit * float64(time.Second))
+}
+
+func (b *bucket) take() {
+	if b.rate.Limit > 0 {
+		b.tokens--
+	}
+}
+
+func (l *TokenBucketLimiter) bucket(target string, now time.Time) *bucket {
+	if l.targets == nil {
+		l.targets = make(map[string]*bucket)
+	}
+	target = targetKey(target)
+	b, ok := l.targets[target]
+	if !ok {
+		rate, ok := l.TargetRates[target]
+		if !ok {
+			rate = l.PerTarget
+		}
+		b = newBucket(rate, now)
+		l.targets[target] = b
+	}
+	return b
+}
+
+// reserve takes a token from both buckets if available, otherwise it returns
+// how long to wait before trying again
+func (l *TokenBucketLimiter) reserve(target string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.client == nil {
+		l.client = newBucket(l.PerClient, now)
+	}
+	b := l.bucket(target, now)
+
+	wait := l.client.delay(now)
+	if d := b.delay(now); d > wait {
+		wait = d
+	}
+	if wait == 0 {
+		l.client.take()
+		b.take()
+	}
+	return wait
+}
+
+// Wait blocks until an attempt to target is allowed, or ctx is done. When
+// FailFast is set it returns ErrRateLimited instead of blocking.
+func (l *TokenBucketLimiter) Wait(ctx context.Context, target string) error {
+	for {
+		wait := l.reserve(target)
+		if wait == 0 {
+			return nil
+		}
+		if l.FailFast {
+			return ErrRateLimited
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Observe holds off target until the Retry-After of a 429 response
+func (l *TokenBucketLimiter) Observe(target string, r *http.Response, err error) {
+	if err != nil || r == nil || r.StatusCode != http.StatusTooManyRequests {
+		return
+	}
+	d, ok := retryAfter(r)
+	if !ok {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	b := l.bucket(target, now)
+	if hold := now.Add(d); hold.After(b.hold) {
+		b.hold = hold
+	}
+	b.tokens = 0
+}
+
+// retryAfter parses the Retry-After header of r, given either in seconds or
+// as an HTTP date
+func retryAfter(r *http.Response) (time.Duration, bool) {
+	v := r.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		if s < 0 {
+			return 0, false
+		}
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
@@ -0,0 +1,130 @@
+package retrigo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketLimiter(t *testing.T) {
+	l := NewTokenBucketLimiter(Rate{}, Rate{Limit: 100, Burst: 1})
+	ctx := context.Background()
+
+	// Paces attempts to the same target
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		checkErr(t, l.Wait(ctx, "a"), true)
+	}
+	if d := time.Since(start); d < 35*time.Millisecond {
+		t.Fatalf("not paced: %s", d)
+	}
+
+	// Fail fast
+	l.FailFast = true
+	checkErr(t, l.Wait(ctx, "b"), true)
+	if err := l.Wait(ctx, "b"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got: %v", err)
+	}
+
+	// Per target overrides and per client rate
+	l = NewTokenBucketLimiter(Rate{Limit: 1, Burst: 3}, Rate{})
+	l.TargetRates = map[string]Rate{"a": {Limit: 1, Burst: 1}}
+	l.FailFast = true
+	checkErr(t, l.Wait(ctx, "a"), true)
+	checkErr(t, l.Wait(ctx, "a"), false)
+	checkErr(t, l.Wait(ctx, "b"), true)
+	checkErr(t, l.Wait(ctx, "c"), true)
+	checkErr(t, l.Wait(ctx, "d"), false)
+
+	// Blocking waits honour the context
+	l.FailFast = false
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(cctx, "d"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got: %v", err)
+	}
+}
+
+func TestTokenBucketLimiter_RetryAfter(t *testing.T) {
+	l := NewTokenBucketLimiter(Rate{}, Rate{})
+	l.FailFast = true
+	ctx := context.Background()
+
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	// Ignored without Retry-After
+	l.Observe("a", resp, nil)
+	checkErr(t, l.Wait(ctx, "a"), true)
+
+	resp.Header.Set("Retry-After", "1")
+	l.Observe("a", resp, nil)
+	checkErr(t, l.Wait(ctx, "a"), false)
+	checkErr(t, l.Wait(ctx, "b"), true)
+
+	resp.Header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	l.Observe("c", resp, nil)
+	checkErr(t, l.Wait(ctx, "c"), false)
+}
+
+func TestTokenBucketLimiter_paths(t *testing.T) {
+	l := NewTokenBucketLimiter(Rate{}, Rate{})
+	l.TargetRates = map[string]Rate{"http://b:8080": {Limit: 1, Burst: 1}}
+	l.FailFast = true
+	ctx := context.Background()
+
+	// A Retry-After holds off every path of the target
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"1"}}}
+	l.Observe("http://a/x", resp, nil)
+	checkErr(t, l.Wait(ctx, "http://a/y?z=1"), false)
+	checkErr(t, l.Wait(ctx, "http://c/x"), true)
+
+	// and target rates apply to every path
+	checkErr(t, l.Wait(ctx, "http://b:8080/x"), true)
+	checkErr(t, l.Wait(ctx, "http://b:8080/y"), false)
+	if n := len(l.targets); n != 3 {
+		t.Fatalf("expected 3 buckets, got %d", n)
+	}
+}
+
+func TestClient_Do_RateLimiter(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.RateLimiter = NewTokenBucketLimiter(Rate{}, Rate{})
+	// Retry the 429 right away, the limiter holds it off
+	client.CheckForRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			return true, nil
+		}
+		return DefaultRetryPolicy(ctx, resp, err)
+	}
+
+	start := time.Now()
+	resp, err := client.Get(ts.URL)
+	checkErr(t, err, true)
+	resp.Body.Close()
+	if d := time.Since(start); d < 900*time.Millisecond {
+		t.Fatalf("Retry-After not honoured: %s", d)
+	}
+
+	// Fail fast limiters fail the request
+	client.RateLimiter = &TokenBucketLimiter{PerClient: Rate{Limit: 0.1, Burst: 1}, FailFast: true}
+	resp, err = client.Get(ts.URL)
+	checkErr(t, err, true)
+	resp.Body.Close()
+	if _, err = client.Get(ts.URL); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got: %v", err)
+	}
+}
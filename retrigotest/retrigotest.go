@@ -0,0 +1,296 @@
+// Package retrigotest provides scripted HTTP test servers for testing code
+// using retrigo.
+//
+// A Cluster starts one local test server per script. A script is a comma
+// separated list of steps each target goes through, one attempt per step,
+// the last step repeating forever:
+//
+//	"503,503,200"   two 503s, then 200s
+//	"503*3,200"     the same step three times
+//	"down,200"      the connection is dropped without a response
+//	"hang,200"      the response never comes, until the client gives up
+//	"down for 2s"   a step lasting a while instead of a single attempt
+//
+// A time based step never repeats forever: when it comes last the target
+// answers 200 once it's over.
+//
+// Every attempt is recorded, body included, so tests can assert how many
+// attempts each target got and in which order targets were hit.
+package retrigotest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Attempt is a request received by one of the cluster servers
+type Attempt struct {
+	Target int         // Index of the server on the cluster
+	Method string      // Request method
+	URI    string      // Request URI
+	Header http.Header // Request headers
+	Body   []byte      // Request body
+	Status int         // Status answered, zero when dropped or hung
+	Time   time.Time   // When the request was received
+}
+
+type step struct {
+	status int
+	down   bool
+	hang   bool
+	times  int
+	period time.Duration
+}
+
+// server is the scripted state of one cluster server
+type server struct {
+	steps   []step
+	current int
+	count   int
+	started time.Time
+}
+
+// next returns the step the next attempt goes through
+func (s *server) next(now time.Time) step {
+	last := len(s.steps) - 1
+	for {
+		st := s.steps[s.current]
+		if s.current == last {
+			return st
+		}
+		if st.period > 0 {
+			if s.started.IsZero() {
+				s.started = now
+			}
+			if now.Sub(s.started) < st.period {
+				return st
+			}
+			s.current, s.started = s.current+1, time.Time{}
+			continue
+		}
+		s.count++
+		if s.count >= st.times {
+			s.current, s.count = s.current+1, 0
+		}
+		return st
+	}
+}
+
+// Cluster is a set of scripted test servers
+type Cluster struct {
+	Servers []*httptest.Server
+
+	mu       sync.Mutex
+	scripts  []*server
+	attempts []Attempt
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// parseScript parses a script into its steps
+func parseScript(script string) ([]step, error) {
+	var steps []step
+	for _, raw := range strings.Split(script, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		st := step{times: 1}
+
+		if i := strings.Index(raw, " for "); i >= 0 {
+			d, err := time.ParseDuration(strings.TrimSpace(raw[i+5:]))
+			if err != nil {
+				return nil, fmt.Errorf("step %q: %w", raw, err)
+			}
+			st.period = d
+			raw = strings.TrimSpace(raw[:i])
+		} else if i := strings.Index(raw, "*"); i >= 0 {
+			n, err := strconv.Atoi(strings.TrimSpace(raw[i+1:]))
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("step %q: bad repeat count", raw)
+			}
+			st.times = n
+			raw = strings.TrimSpace(raw[:i])
+		}
+
+		switch raw {
+		case "down":
+			st.down = true
+		case "hang":
+			st.hang = true
+		default:
+			code, err := strconv.Atoi(raw)
+			if err != nil || code < 100 || code > 999 {
+				return nil, fmt.Errorf("step %q: unknown step", raw)
+			}
+			st.status = code
+		}
+		steps = append(steps, st)
+	}
+	// A final time based step is followed by 200s rather than repeated
+	if len(steps) == 0 || steps[len(steps)-1].period > 0 {
+		steps = append(steps, step{status: http.StatusOK, times: 1})
+	}
+	return steps, nil
+}
+
+// NewCluster starts one test server per script. The servers are closed when
+// the test finishes.
+func NewCluster(t testing.TB, scripts ...string) *Cluster {
+	t.Helper()
+
+	c := &Cluster{closed: make(chan struct{})}
+	for i, script := range scripts {
+		steps, err := parseScript(script)
+		if err != nil {
+			c.Close()
+			t.Fatalf("retrigotest: target %d: %v", i, err)
+		}
+		c.scripts = append(c.scripts, &server{steps: steps})
+		c.Servers = append(c.Servers, httptest.NewServer(c.handler(i)))
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *Cluster) handler(target int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		c.mu.Lock()
+		now := time.Now()
+		st := c.scripts[target].next(now)
+		attempt := Attempt{
+			Target: target,
+			Method: r.Method,
+			URI:    r.RequestURI,
+			Header: r.Header.Clone(),
+			Body:   body,
+			Status: st.status,
+			Time:   now,
+		}
+		c.attempts = append(c.attempts, attempt)
+		c.mu.Unlock()
+
+		switch {
+		case st.down:
+			if hj, ok := w.(http.Hijacker); ok {
+				if conn, _, err := hj.Hijack(); err == nil {
+					conn.Close()
+					return
+				}
+			}
+			panic(http.ErrAbortHandler)
+		case st.hang:
+			select {
+			case <-r.Context().Done():
+			case <-c.closed:
+			}
+		default:
+			w.WriteHeader(st.status)
+		}
+	}
+}
+
+// URL returns the url of the i-th server
+func (c *Cluster) URL(i int) string {
+	return c.Servers[i].URL
+}
+
+// URLs returns the urls of all servers, each followed by path, as the space
+// separated list accepted by retrigo
+func (c *Cluster) URLs(path string) string {
+	urls := make([]string, len(c.Servers))
+	for i, s := range c.Servers {
+		urls[i] = s.URL + path
+	}
+	return strings.Join(urls, " ")
+}
+
+// Attempts returns every attempt received so far, in order
+func (c *Cluster) Attempts() []Attempt {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Attempt(nil), c.attempts...)
+}
+
+// AttemptsTo returns the attempts received by the i-th server, in order
+func (c *Cluster) AttemptsTo(i int) []Attempt {
+	var attempts []Attempt
+	for _, a := range c.Attempts() {
+		if a.Target == i {
+			attempts = append(attempts, a)
+		}
+	}
+	return attempts
+}
+
+// Order returns the index of the server each attempt was received by
+func (c *Cluster) Order() []int {
+	attempts := c.Attempts()
+	order := make([]int, len(attempts))
+	for i, a := range attempts {
+		order[i] = a.Target
+	}
+	return order
+}
+
+// Close shuts the servers down, releasing hung requests
+func (c *Cluster) Close() {
+	c.once.Do(func() {
+		close(c.closed)
+		for _, s := range c.Servers {
+			s.CloseClientConnections()
+			s.Close()
+		}
+	})
+}
+
+// AssertAttempts fails the test unless the i-th server received n attempts
+func (c *Cluster) AssertAttempts(t testing.TB, i, n int) {
+	t.Helper()
+	if got := len(c.AttemptsTo(i)); got != n {
+		t.Errorf("target %d: got %d attempts, expected %d", i, got, n)
+	}
+}
+
+// AssertTotal fails the test unless the cluster received n attempts
+func (c *Cluster) AssertTotal(t testing.TB, n int) {
+	t.Helper()
+	if got := len(c.Attempts()); got != n {
+		t.Errorf("got %d attempts, expected %d", got, n)
+	}
+}
+
+// AssertOrder fails the test unless the servers were hit in order
+func (c *Cluster) AssertOrder(t testing.TB, order ...int) {
+	t.Helper()
+	got := c.Order()
+	if len(got) != len(order) {
+		t.Errorf("got target order %v, expected %v", got, order)
+		return
+	}
+	for i := range got {
+		if got[i] != order[i] {
+			t.Errorf("got target order %v, expected %v", got, order)
+			return
+		}
+	}
+}
+
+// AssertBodies fails the test unless every attempt carried body
+func (c *Cluster) AssertBodies(t testing.TB, body string) {
+	t.Helper()
+	for i, a := range c.Attempts() {
+		if string(a.Body) != body {
+			t.Errorf("attempt %d to target %d: got body %q, expected %q", i, a.Target, a.Body, body)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package retrigotest_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/wolviecb/retrigo"
+	"github.com/wolviecb/retrigo/retrigotest"
+)
+
+func newClient() *retrigo.Client {
+	c := retrigo.NewClient()
+	c.RetryWaitMin = time.Millisecond
+	c.RetryWaitMax = time.Millisecond
+	c.RetryMax = 10
+	c.Logger = func(req *retrigo.Request, mtype, msg string, err error) {}
+	return c
+}
+
+func TestCluster(t *testing.T) {
+	cluster := retrigotest.NewCluster(t, "503,down,200", "503*2,200")
+
+	resp, err := newClient().Post(cluster.URLs("/foo"), "text/plain", []byte("hello"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	// Round-robin: 503 (0), 503 (1), down (0), 503 (1), 200 (0)
+	cluster.AssertOrder(t, 0, 1, 0, 1, 0)
+	cluster.AssertAttempts(t, 0, 3)
+	cluster.AssertAttempts(t, 1, 2)
+	cluster.AssertTotal(t, 5)
+	cluster.AssertBodies(t, "hello")
+
+	attempts := cluster.AttemptsTo(0)
+	if attempts[0].URI != "/foo" || attempts[0].Method != "POST" || attempts[2].Status != 200 {
+		t.Fatalf("bad attempts: %#v", attempts)
+	}
+	if attempts[1].Status != 0 {
+		t.Fatalf("dropped attempt has status %d", attempts[1].Status)
+	}
+}
+
+func TestCluster_period(t *testing.T) {
+	cluster := retrigotest.NewCluster(t, "down for 100ms,200")
+
+	c := newClient()
+	c.RetryWaitMin = 20 * time.Millisecond
+	c.RetryWaitMax = 20 * time.Millisecond
+	start := time.Now()
+	resp, err := c.Get(cluster.URL(0))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if time.Since(start) < 100*time.Millisecond {
+		t.Fatal("target came back too early")
+	}
+	if n := len(cluster.Attempts()); n < 3 {
+		t.Fatalf("expected several attempts, got %d", n)
+	}
+}
+
+func TestCluster_finalPeriod(t *testing.T) {
+	cluster := retrigotest.NewCluster(t, "503 for 50ms")
+
+	c := newClient()
+	c.RetryWaitMin = 20 * time.Millisecond
+	c.RetryWaitMax = 20 * time.Millisecond
+	resp, err := c.Get(cluster.URL(0))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected 200 after the period, got %d", resp.StatusCode)
+	}
+}
+
+func TestCluster_hang(t *testing.T) {
+	cluster := retrigotest.NewCluster(t, "hang,200")
+
+	c := newClient()
+	c.HTTPClient.Timeout = 50 * time.Millisecond
+	resp, err := c.Get(cluster.URL(0))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	cluster.AssertAttempts(t, 0, 2)
+
+	// Hung requests are released on Close
+	cluster = retrigotest.NewCluster(t, "hang")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", cluster.URL(0), nil)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cluster.Close()
+	}()
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+	if ctx.Err() != nil {
+		t.Fatal("hung request was not released")
+	}
+}
+
+func TestCluster_badScript(t *testing.T) {
+	for _, script := range []string{"ok", "503*0", "down for ever", "42"} {
+		ft := &fakeT{TB: t}
+		func() {
+			defer func() { recover() }()
+			retrigotest.NewCluster(ft, script)
+		}()
+		if !ft.failed {
+			t.Fatalf("script %q should fail", script)
+		}
+	}
+}
+
+// fakeT records fatal failures instead of ending the test
+type fakeT struct {
+	testing.TB
+	failed bool
+}
+
+func (f *fakeT) Fatalf(format string, args ...interface{}) {
+	f.failed = true
+	panic("fatal")
+}
+
+func (f *fakeT) Helper() {}
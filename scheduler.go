@@ -0,0 +1,208 @@
+package retrigo
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// live returns the targets which haven't failed during the current Do. Once
+// every target has failed all of them are eligible again.
+func live(req *Request, targets []*Target) []*Target {
+	alive := make([]*Target, 0, len(targets))
+	for _, t := range targets {
+		if !req.Failed(t) {
+			alive = append(alive, t)
+		}
+	}
+	if len(alive) == 0 {
+		return targets
+	}
+	return alive
+}
+
+// weight returns the target weight, a zero weight counts as one
+func weight(t *Target) int {
+	if t.Weight <= 0 {
+		return 1
+	}
+	return t.Weight
+}
+
+// weightedPick picks one of targets at random, proportionally to their
+// weights. The top level math/rand functions are safe for concurrent use.
+func weightedPick(targets []*Target) *Target {
+	total := 0
+	for _, t := range targets {
+		total += weight(t)
+	}
+	n := rand.Intn(total)
+	for _, t := range targets {
+		n -= weight(t)
+		if n < 0 {
+			return t
+		}
+	}
+	return targets[len(targets)-1]
+}
+
+// bestTier returns the targets of the lowest tier, as given by tier, among
+// the targets which haven't failed during the current Do.
+func bestTier(req *Request, targets []*Target, tier func(t *Target) int) []*Target {
+	alive := live(req, targets)
+
+	best := tier(alive[0])
+	for _, t := range alive {
+		if n := tier(t); n < best {
+			best = n
+		}
+	}
+	picked := make([]*Target, 0, len(alive))
+	for _, t := range alive {
+		if tier(t) == best {
+			picked = append(picked, t)
+		}
+	}
+	return picked
+}
+
+// PriorityScheduler is a TargetScheduler which honours Target.Priority and
+// Target.Weight the way SRV records are meant to be used: attempts go to the
+// lowest priority tier that still has targets which haven't failed during the
+// current Do, picking among them at random proportionally to their weight.
+func PriorityScheduler(req *Request, targets []*Target, j int) (*Target, int) {
+	tier := bestTier(req, targets, func(t *Target) int {
+		return t.Priority
+	})
+	return weightedPick(tier), j + 1
+}
+
+// TierScheduler returns a TargetScheduler which prefers targets by the value
+// of their label, for instance a zone: targets labeled order[0] are used
+// first, the ones labeled order[1] only once every order[0] target failed
+// during the current Do, and so on. Targets whose label isn't listed come
+// last. Within a tier attempts are round-robined, across all requests using
+// the scheduler.
+func TierScheduler(label string, order ...string) TargetScheduler {
+	position := make(map[string]int, len(order))
+	for i, v := range order {
+		if _, ok := position[v]; !ok {
+			position[v] = i
+		}
+	}
+	tierOf := func(t *Target) int {
+		if i, ok := position[t.Labels[label]]; ok {
+			return i
+		}
+		return len(order)
+	}
+
+	var next uint64
+	return func(req *Request, targets []*Target, j int) (*Target, int) {
+		tier := bestTier(req, targets, tierOf)
+		n := atomic.AddUint64(&next, 1) - 1
+		return tier[n%uint64(len(tier))], j + 1
+	}
+}
+
+// RandomScheduler is a TargetScheduler which picks uniformly at random among
+// the targets that haven't failed during the current Do.
+func RandomScheduler(req *Request, targets []*Target, j int) (*Target, int) {
+	alive := live(req, targets)
+	return alive[rand.Intn(len(alive))], j + 1
+}
+
+// PowerOfTwoScheduler returns a TargetScheduler implementing the "power of two
+// choices" strategy: it picks two random targets among the ones that haven't
+// failed during the current Do and sends the attempt to the one with fewer
+// attempts in flight on c.
+func PowerOfTwoScheduler(c *Client) TargetScheduler {
+	return func(req *Request, targets []*Target, j int) (*Target, int) {
+		alive := live(req, targets)
+		if len(alive) == 1 {
+			return alive[0], j + 1
+		}
+
+		a := rand.Intn(len(alive))
+		b := rand.Intn(len(alive) - 1)
+		if b >= a {
+			b++
+		}
+		if c.InFlight(alive[b].String()) < c.InFlight(alive[a].String()) {
+			a = b
+		}
+		return alive[a], j + 1
+	}
+}
+
+// HashScheduler returns a TargetScheduler which routes requests with the same
+// key, as returned by key, consistently to the same target. It uses weighted
+// rendezvous hashing, so adding or removing a target only moves the keys that
+// belong to it. When the preferred target fails during a Do, the attempts
+// fall over to the next target in the key's ranking.
+func HashScheduler(key func(req *Request) string) TargetScheduler {
+	return func(req *Request, targets []*Target, j int) (*Target, int) {
+		ranked := rank(key(req), targets)
+		for _, t := range ranked {
+			if !req.Failed(t) {
+				return t, j + 1
+			}
+		}
+		// Every target failed, keep walking the ranking
+		if j < 0 {
+			j = 0
+		}
+		return ranked[j%len(ranked)], j + 1
+	}
+}
+
+// rank orders targets by their rendezvous hashing score for k. Targets are
+// scored by their scheme and host, so the ranking of a key doesn't depend on
+// the path of the request.
+func rank(k string, targets []*Target) []*Target {
+	scores := make(map[*Target]float64, len(targets))
+	for _, t := range targets {
+		h := fnv.New64a()
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(t.key()))
+		// Map the hash to (0, 1) and weight it, see "Weighted Distributed
+		// Hash Tables" by Schindelhauer and Schomaker.
+		u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+		scores[t] = -float64(weight(t)) / math.Log(u)
+	}
+
+	ranked := append([]*Target(nil), targets...)
+	sort.SliceStable(ranked, func(a, b int) bool {
+		return scores[ranked[a]] > scores[ranked[b]]
+	})
+	return ranked
+}
+
+// HeaderKey returns a HashScheduler key function using the value of the
+// request header name
+func HeaderKey(name string) func(req *Request) string {
+	return func(req *Request) string {
+		return req.Header.Get(name)
+	}
+}
+
+// PathSegmentKey returns a HashScheduler key function using the i-th segment
+// (zero based) of the request path, e.g. 1 for the tenant in /tenants/foo/x.
+// The path is the one the request was created with, so base paths of the
+// client Targets don't shift the segments.
+func PathSegmentKey(i int) func(req *Request) string {
+	return func(req *Request) string {
+		if len(req.targets) == 0 {
+			return ""
+		}
+		segments := strings.Split(strings.Trim(req.targets[0].URL.Path, "/"), "/")
+		if i < 0 || i >= len(segments) {
+			return ""
+		}
+		return segments[i]
+	}
+}
@@ -0,0 +1,293 @@
+package retrigo
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPriorityScheduler(t *testing.T) {
+	targets := []*Target{
+		{URL: mustParse(t, "http://a"), Priority: 1, Weight: 1},
+		{URL: mustParse(t, "http://b"), Priority: 1, Weight: 3},
+		{URL: mustParse(t, "http://c"), Priority: 2},
+	}
+	req, err := NewRequestWithTargets("GET", targets, nil)
+	checkErr(t, err, true)
+
+	// Only the lowest tier is used, proportionally to the weights
+	hits := map[*Target]int{}
+	for i := 0; i < 4000; i++ {
+		target, _ := PriorityScheduler(req, targets, i)
+		hits[target]++
+	}
+	if hits[targets[2]] != 0 {
+		t.Fatalf("higher tier used: %v", hits)
+	}
+	if hits[targets[1]] < 2*hits[targets[0]] {
+		t.Fatalf("weights not honoured: %v", hits)
+	}
+
+	// Moves to the next tier once the lowest one failed
+	req.failed = map[*Target]bool{targets[0]: true, targets[1]: true}
+	if target, _ := PriorityScheduler(req, targets, 0); target != targets[2] {
+		t.Fatalf("expected next tier, got: %s", target)
+	}
+
+	// And starts over once every target failed
+	req.failed[targets[2]] = true
+	if target, _ := PriorityScheduler(req, targets, 0); target == targets[2] {
+		t.Fatalf("expected lowest tier, got: %s", target)
+	}
+}
+
+func TestClient_Do_PriorityScheduler(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	targets := []*Target{
+		{URL: mustParse(t, "http://127.0.0.1:1"), Priority: 1},
+		{URL: mustParse(t, "http://127.0.0.1:2"), Priority: 1},
+		{URL: mustParse(t, ts.URL), Priority: 2},
+	}
+	req, err := NewRequestWithTargets("GET", targets, nil)
+	checkErr(t, err, true)
+
+	client := NewClient()
+	client.RetryMax = 2
+	client.TargetScheduler = PriorityScheduler
+	resp, err := client.Do(req)
+	checkErr(t, err, true)
+	resp.Body.Close()
+}
+
+func TestRandomScheduler(t *testing.T) {
+	targets := URLTargets(mustParse(t, "http://a"), mustParse(t, "http://b"), mustParse(t, "http://c"))
+	req, err := NewRequestWithTargets("GET", targets, nil)
+	checkErr(t, err, true)
+
+	hits := map[*Target]int{}
+	for i := 0; i < 3000; i++ {
+		target, _ := RandomScheduler(req, targets, i)
+		hits[target]++
+	}
+	for _, target := range targets {
+		if hits[target] < 800 {
+			t.Fatalf("not uniform: %v", hits)
+		}
+	}
+
+	// Skips failed targets
+	req.failed = map[*Target]bool{targets[0]: true, targets[1]: true}
+	for i := 0; i < 100; i++ {
+		if target, _ := RandomScheduler(req, targets, i); target != targets[2] {
+			t.Fatalf("picked failed target: %s", target)
+		}
+	}
+}
+
+func TestPowerOfTwoScheduler(t *testing.T) {
+	targets := URLTargets(mustParse(t, "http://a"), mustParse(t, "http://b"))
+	req, err := NewRequestWithTargets("GET", targets, nil)
+	checkErr(t, err, true)
+
+	client := NewClient()
+	scheduler := PowerOfTwoScheduler(client)
+
+	// With two targets the busier one is never picked
+	done := client.track("http://a")
+	if client.InFlight("http://a") != 1 {
+		t.Fatalf("bad in-flight count: %d", client.InFlight("http://a"))
+	}
+	for i := 0; i < 100; i++ {
+		if target, _ := scheduler(req, targets, i); target != targets[1] {
+			t.Fatalf("picked busy target: %s", target)
+		}
+	}
+	done()
+	if client.InFlight("http://a") != 0 {
+		t.Fatalf("bad in-flight count: %d", client.InFlight("http://a"))
+	}
+
+	// Attempts are counted per target whatever their path, and forgotten
+	// once done
+	done = client.track("http://a/x?y=1")
+	if client.InFlight("http://a/z") != 1 || client.InFlight("http://a") != 1 {
+		t.Fatalf("bad in-flight count: %d", client.InFlight("http://a"))
+	}
+	done()
+	if n := len(client.inflight); n != 0 {
+		t.Fatalf("expected no targets tracked, got %d", n)
+	}
+}
+
+func TestClient_Do_PowerOfTwoScheduler(t *testing.T) {
+	ts1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer ts1.Close()
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer ts2.Close()
+
+	client := NewClient()
+	client.TargetScheduler = PowerOfTwoScheduler(client)
+
+	// Safe for concurrent use
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(ts1.URL + " " + ts2.URL + " http://127.0.0.1:1")
+			if err != nil {
+				t.Errorf("err: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	for _, target := range []string{ts1.URL, ts2.URL, "http://127.0.0.1:1"} {
+		if n := client.InFlight(target); n != 0 {
+			t.Fatalf("%s still has %d attempts in flight", target, n)
+		}
+	}
+}
+
+func TestHashScheduler(t *testing.T) {
+	var targets []*Target
+	for _, h := range []string{"a", "b", "c", "d", "e"} {
+		targets = append(targets, &Target{URL: mustParse(t, "http://"+h)})
+	}
+	scheduler := HashScheduler(HeaderKey("X-Tenant"))
+
+	pick := func(key string, targets []*Target) *Target {
+		req, err := NewRequestWithTargets("GET", targets, nil)
+		checkErr(t, err, true)
+		req.Header.Set("X-Tenant", key)
+		target, _ := scheduler(req, targets, 0)
+		return target
+	}
+
+	// Same key, same target
+	keys := make([]string, 1000)
+	before := map[string]*Target{}
+	hits := map[*Target]int{}
+	for i := range keys {
+		keys[i] = "tenant-" + string(rune('a'+i%26)) + string(rune('a'+i/26))
+		before[keys[i]] = pick(keys[i], targets)
+		hits[before[keys[i]]]++
+		if pick(keys[i], targets) != before[keys[i]] {
+			t.Fatalf("key %s moved", keys[i])
+		}
+	}
+	for _, target := range targets {
+		if hits[target] < 100 {
+			t.Fatalf("bad spread: %v", hits)
+		}
+	}
+
+	// Removing a target only moves its own keys
+	removed := targets[2]
+	rest := []*Target{targets[0], targets[1], targets[3], targets[4]}
+	for _, k := range keys {
+		after := pick(k, rest)
+		if before[k] != removed && after != before[k] {
+			t.Fatalf("key %s moved from %s to %s", k, before[k], after)
+		}
+	}
+
+	// Falls over to the next target in the ranking
+	req, err := NewRequestWithTargets("GET", targets, nil)
+	checkErr(t, err, true)
+	req.Header.Set("X-Tenant", keys[0])
+	first, _ := scheduler(req, targets, 0)
+	req.failed = map[*Target]bool{first: true}
+	second, _ := scheduler(req, targets, 1)
+	if second == first {
+		t.Fatalf("did not fall over: %s", second)
+	}
+	if second != rank(keys[0], targets)[1] {
+		t.Fatalf("expected next in ranking, got: %s", second)
+	}
+}
+
+func TestHashScheduler_paths(t *testing.T) {
+	hosts := []string{"a", "b", "c", "d", "e"}
+	scheduler := HashScheduler(HeaderKey("X-Tenant"))
+	pick := func(key, path string) string {
+		urls := make([]string, len(hosts))
+		for i, h := range hosts {
+			urls[i] = "http://" + h + path
+		}
+		req, err := NewRequest("GET", strings.Join(urls, " "), nil)
+		checkErr(t, err, true)
+		req.Header.Set("X-Tenant", key)
+		target, _ := scheduler(req, req.Targets(), 0)
+		return target.URL.Host
+	}
+
+	// The same key sticks to the same host whatever the path
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("tenant-%d", i)
+		if a, b := pick(key, "/orders/42"), pick(key, "/v2/profile?x=1"); a != b {
+			t.Fatalf("key %s moved from %s to %s with the path", key, a, b)
+		}
+	}
+}
+
+func TestPathSegmentKey(t *testing.T) {
+	req, err := NewRequest("GET", "http://a/tenants/foo/x http://b/tenants/foo/x", nil)
+	checkErr(t, err, true)
+	if k := PathSegmentKey(1)(req); k != "foo" {
+		t.Fatalf("bad key: %s", k)
+	}
+	if k := PathSegmentKey(5)(req); k != "" {
+		t.Fatalf("bad key: %s", k)
+	}
+}
+
+func TestTierScheduler(t *testing.T) {
+	zone := func(raw, z string) *Target {
+		return &Target{URL: mustParse(t, raw), Labels: map[string]string{"zone": z}}
+	}
+	targets := []*Target{
+		zone("http://c1", "c"),
+		zone("http://a1", "a"),
+		zone("http://b1", "b"),
+		zone("http://a2", "a"),
+		{URL: mustParse(t, "http://x")},
+	}
+	scheduler := TierScheduler("zone", "a", "b", "c")
+	req, err := NewRequestWithTargets("GET", targets, nil)
+	checkErr(t, err, true)
+
+	// Round-robins the local tier
+	hits := map[*Target]int{}
+	for i := 0; i < 10; i++ {
+		target, _ := scheduler(req, targets, i)
+		hits[target]++
+	}
+	if hits[targets[1]] != 5 || hits[targets[3]] != 5 {
+		t.Fatalf("bad local tier spread: %v", hits)
+	}
+
+	// Walks the tiers in order as targets fail
+	expect := []*Target{targets[2], targets[0], targets[4]}
+	req.failed = map[*Target]bool{targets[1]: true, targets[3]: true}
+	for _, e := range expect {
+		target, _ := scheduler(req, targets, 0)
+		if target != e {
+			t.Fatalf("expected %s, got %s", e, target)
+		}
+		req.failed[target] = true
+	}
+}
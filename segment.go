@@ -0,0 +1,296 @@
+package retrigo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultSegmentSize is the default size of the byte ranges fetched by
+	// DownloadSegments
+	DefaultSegmentSize int64 = 8 << 20
+
+	// ErrChecksum is returned by DownloadSegments, wrapped, when the
+	// downloaded content doesn't match SegmentOptions.Sum
+	ErrChecksum = errors.New("checksum mismatch")
+)
+
+// SegmentOptions configures DownloadSegments
+type SegmentOptions struct {
+	SegmentSize int64 // Size of each byte range, DefaultSegmentSize when zero
+	Concurrency int   // Segments fetched at the same time, the number of targets when zero
+
+	// Hash and Sum, when set, verify the downloaded content: Sum must be
+	// the Hash checksum of the whole content. The destination must then be
+	// an io.ReaderAt as well, such as an *os.File, to read it back.
+	Hash func() hash.Hash
+	Sum  []byte
+}
+
+// offsetWriter writes to w sequentially from off, keeping the write error
+// apart from read errors
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+	err error
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+	o.err = err
+	return n, err
+}
+
+// segment is a byte range of a segmented download
+type segment struct {
+	index      int
+	start, end int64 // Inclusive range
+}
+
+// DownloadSegments fetches the resource of the GET request req into w, in
+// byte ranges of opts.SegmentSize fetched in parallel. Each segment starts on
+// a different target, in turn, so the load is spread over every mirror of
+// the resource; a segment which fails is retried on the next target, resuming
+// from the bytes it already got, with its own RetryMax budget. Targets which
+// don't answer a range, such as mirrors lacking the resource, are skipped.
+// The size of the resource is learnt with a HEAD request, and every range
+// answered must agree with it. When the size is unknown or no target
+// advertises byte ranges with Accept-Ranges the resource is fetched with
+// Download instead.
+//
+// It returns the number of bytes written to w.
+func (c *Client) DownloadSegments(req *Request, w io.WriterAt, opts *SegmentOptions) (int64, error) {
+	if opts == nil {
+		opts = &SegmentOptions{}
+	}
+	if opts.Hash != nil {
+		if _, ok := w.(io.ReaderAt); !ok {
+			return 0, errors.New("checksum verification needs an io.ReaderAt destination")
+		}
+	}
+	size := opts.SegmentSize
+	if size <= 0 {
+		size = DefaultSegmentSize
+	}
+
+	targets, err := c.resolve(req)
+	if err != nil {
+		return 0, err
+	}
+
+	length, err := c.contentLength(req, targets)
+	if err != nil {
+		return 0, err
+	}
+
+	var written int64
+	if length < 0 {
+		written, err = c.downloadWhole(req, targets, w)
+	} else {
+		written, err = c.downloadSegments(req, targets, w, length, size, opts.Concurrency)
+	}
+	if err != nil {
+		return written, err
+	}
+
+	if opts.Hash != nil {
+		h := opts.Hash()
+		if _, err := io.Copy(h, io.NewSectionReader(w.(io.ReaderAt), 0, written)); err != nil {
+			return written, err
+		}
+		if sum := h.Sum(nil); !bytes.Equal(sum, opts.Sum) {
+			return written, fmt.Errorf("%s %s: %w: got %x, expected %x", req.Method, req.URL, ErrChecksum, sum, opts.Sum)
+		}
+	}
+	return written, nil
+}
+
+// contentLength asks the targets for the size of the resource with HEAD
+// requests, moving on to the next target until one advertises byte ranges.
+// It is -1 when the size is unknown or none does, as servers which don't know
+// about ranges send no Accept-Ranges at all.
+func (c *Client) contentLength(req *Request, targets []*Target) (int64, error) {
+	var status error
+	ok := false
+	for k := range targets {
+		next := rotate(targets, k)
+		head := &Request{Request: req.Request.Clone(req.Context()), urls: targetURLs(next), targets: next}
+		head.Method = http.MethodHead
+		resp, err := c.Do(head)
+		if err != nil {
+			return 0, err
+		}
+		c.drainBody(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			status = fmt.Errorf("%s %s: %s", head.Method, head.URL, resp.Status)
+			continue
+		}
+		ok = true
+		if strings.EqualFold(strings.TrimSpace(resp.Header.Get("Accept-Ranges")), "bytes") {
+			return resp.ContentLength, nil
+		}
+	}
+	if !ok {
+		return 0, status
+	}
+	return -1, nil
+}
+
+// downloadWhole fetches the resource in a single resumable stream
+func (c *Client) downloadWhole(req *Request, targets []*Target, w io.WriterAt) (int64, error) {
+	get := &Request{Request: req.Request.Clone(req.Context()), urls: targetURLs(targets), targets: targets}
+	resp, err := c.Download(get)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s %s: %s", get.Method, get.URL, resp.Status)
+	}
+	out := &offsetWriter{w: w}
+	_, err = io.Copy(out, resp.Body)
+	return out.off, err
+}
+
+func (c *Client) downloadSegments(req *Request, targets []*Target, w io.WriterAt, length, size int64, concurrency int) (int64, error) {
+	if concurrency <= 0 {
+		concurrency = len(targets)
+	}
+
+	var segments []segment
+	for start := int64(0); start < length; start += size {
+		end := start + size - 1
+		if end >= length {
+			end = length - 1
+		}
+		segments = append(segments, segment{index: len(segments), start: start, end: end})
+	}
+	if concurrency > len(segments) {
+		concurrency = len(segments)
+	}
+
+	// the first failed segment cancels the others
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	queue := make(chan segment)
+	var (
+		mu       sync.Mutex
+		written  int64
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range queue {
+				n, err := c.fetchSegment(ctx, req, rotate(targets, s.index), w, s, length)
+				mu.Lock()
+				written += n
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, s := range segments {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- s
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return written, firstErr
+	}
+	if written != length {
+		return written, fmt.Errorf("%s %s: got %d bytes, expected %d", req.Method, req.URL, written, length)
+	}
+	return written, nil
+}
+
+// fetchSegment fetches the byte range s into w, moving on to the next of
+// targets when an attempt or the body fails. Targets which don't answer the
+// range, such as mirrors lacking the resource or ignoring ranges, are left
+// out of the segment, which fails once every target refused it.
+func (c *Client) fetchSegment(ctx context.Context, req *Request, targets []*Target, w io.WriterAt, s segment, length int64) (int64, error) {
+	out := &offsetWriter{w: w, off: s.start}
+	attempts := 0
+	refused := make(map[string]bool)
+	var refusal error
+	for k := 0; ; k++ {
+		var next []*Target
+		for _, t := range rotate(targets, k) {
+			if !refused[t.String()] {
+				next = append(next, t)
+			}
+		}
+		if len(next) == 0 {
+			return out.off - s.start, refusal
+		}
+		httpReq := req.Request.Clone(ctx)
+		httpReq.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", out.off, s.end))
+		retryMax := c.RetryMax - attempts
+		r := &Request{Request: httpReq, urls: targetURLs(next), targets: next, retryMax: &retryMax}
+
+		resp, err := c.Do(r)
+		attempts += r.Attempts()
+		if err != nil {
+			return out.off - s.start, err
+		}
+		want := fmt.Sprintf("bytes %d-%d/%d", out.off, s.end, length)
+		if resp.StatusCode != http.StatusPartialContent || strings.TrimSpace(resp.Header.Get("Content-Range")) != want {
+			c.drainBody(resp.Body)
+			refused[r.URL.String()] = true
+			refusal = fmt.Errorf("%s %s: %w: got %s %q, expected %q",
+				r.Method, r.URL, ErrNotResumable, resp.Status, resp.Header.Get("Content-Range"), want)
+			mtype := "DEBUG"
+			msg := fmt.Sprintf("%s %s: segment %d refused, moving on: ", r.Method, r.URL, s.index)
+			c.Logger(r, mtype, msg, refusal)
+			continue
+		}
+		_, err = io.Copy(out, io.LimitReader(resp.Body, s.end+1-out.off))
+		resp.Body.Close()
+		if err == nil && out.off <= s.end {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil || out.err != nil {
+			return out.off - s.start, err
+		}
+
+		retry, cerr := c.CheckForRetry(ctx, nil, err)
+		if !retry {
+			if cerr != nil {
+				err = cerr
+			}
+			return out.off - s.start, err
+		}
+		if attempts > c.RetryMax {
+			return out.off - s.start, fmt.Errorf("%s %s %w after %d attempts: %v", r.Method, r.URL, ErrGiveUp, c.RetryMax, err)
+		}
+		wait := c.Backoff(c.RetryWaitMin, c.RetryWaitMax, attempts-1, nil)
+		mtype := "DEBUG"
+		msg := fmt.Sprintf("%s %s: segment %d failed at byte %d, retrying in %s: ", r.Method, r.URL, s.index, out.off, wait)
+		c.Logger(r, mtype, msg, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return out.off - s.start, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package retrigo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mirror serves content, counting the range requests received
+func mirror(content []byte, ranges *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			atomic.AddInt32(ranges, 1)
+		}
+		http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+func tempFile(t *testing.T) *os.File {
+	f, err := os.Create(filepath.Join(t.TempDir(), "blob"))
+	checkErr(t, err, true)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestClient_DownloadSegments(t *testing.T) {
+	content := make([]byte, 100000)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	sum := sha256.Sum256(content)
+
+	var ranges1, ranges2 int32
+	ts1 := mirror(content, &ranges1)
+	defer ts1.Close()
+	ts2 := mirror(content, &ranges2)
+	defer ts2.Close()
+
+	client := NewClient()
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	faults := NewFaultTransport(client.HTTPClient.Transport,
+		&FaultRule{Host: ts1.Listener.Addr().String(), Times: 3, Truncate: true, TruncateAfter: 1000})
+	client.HTTPClient.Transport = faults
+
+	req, err := NewRequest("GET", ts1.URL+" "+ts2.URL, nil)
+	checkErr(t, err, true)
+	f := tempFile(t)
+	n, err := client.DownloadSegments(req, f, &SegmentOptions{
+		SegmentSize: 10000,
+		Concurrency: 3,
+		Hash:        sha256.New,
+		Sum:         sum[:],
+	})
+	checkErr(t, err, true)
+	if n != int64(len(content)) {
+		t.Fatalf("bad length: %d", n)
+	}
+	got, err := os.ReadFile(f.Name())
+	checkErr(t, err, true)
+	if !bytes.Equal(got, content) {
+		t.Fatal("bad content")
+	}
+
+	// The first fault hits the HEAD request, the other two cut segments short
+	if len(faults.Faults()) != 3 {
+		t.Fatalf("expected 3 faults, got %d", len(faults.Faults()))
+	}
+	r1, r2 := atomic.LoadInt32(&ranges1), atomic.LoadInt32(&ranges2)
+	if r1 < 5 || r2 < 5 || r1+r2 != 12 {
+		t.Fatalf("segments not spread over mirrors: %d %d", r1, r2)
+	}
+}
+
+func TestClient_DownloadSegments_checksum(t *testing.T) {
+	content := bytes.Repeat([]byte("z"), 5000)
+	var ranges int32
+	ts := mirror(content, &ranges)
+	defer ts.Close()
+
+	client := NewClient()
+	req, err := NewRequest("GET", ts.URL, nil)
+	checkErr(t, err, true)
+	_, err = client.DownloadSegments(req, tempFile(t), &SegmentOptions{
+		SegmentSize: 1000,
+		Hash:        sha256.New,
+		Sum:         make([]byte, sha256.Size),
+	})
+	if !errors.Is(err, ErrChecksum) {
+		t.Fatalf("expected ErrChecksum, got %v", err)
+	}
+	if atomic.LoadInt32(&ranges) != 5 {
+		t.Fatalf("expected 5 segments, got %d", ranges)
+	}
+}
+
+func TestClient_DownloadSegments_noRanges(t *testing.T) {
+	content := bytes.Repeat([]byte("w"), 5000)
+	// Ranges refused, or unknown to the server which answers them with 200
+	for _, acceptRanges := range []string{"none", ""} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if acceptRanges != "" {
+				w.Header().Set("Accept-Ranges", acceptRanges)
+			}
+			w.Write(content)
+		}))
+
+		client := NewClient()
+		req, err := NewRequest("GET", ts.URL, nil)
+		checkErr(t, err, true)
+		f := tempFile(t)
+		n, err := client.DownloadSegments(req, f, &SegmentOptions{SegmentSize: 1000})
+		ts.Close()
+		checkErr(t, err, true)
+		got, err := os.ReadFile(f.Name())
+		checkErr(t, err, true)
+		if n != 5000 || !bytes.Equal(got, content) {
+			t.Fatalf("Accept-Ranges %q: bad content: %d bytes", acceptRanges, n)
+		}
+	}
+}
+
+func TestClient_DownloadSegments_missingMirror(t *testing.T) {
+	content := bytes.Repeat([]byte("m"), 5000)
+	var ranges int32
+	good := mirror(content, &ranges)
+	defer good.Close()
+	var missing int32
+	gone := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&missing, 1)
+		http.NotFound(w, r)
+	}))
+	defer gone.Close()
+
+	client := NewClient()
+	req, err := NewRequest("GET", gone.URL+" "+good.URL, nil)
+	checkErr(t, err, true)
+	f := tempFile(t)
+	n, err := client.DownloadSegments(req, f, &SegmentOptions{SegmentSize: 1000, Concurrency: 1})
+	checkErr(t, err, true)
+	got, err := os.ReadFile(f.Name())
+	checkErr(t, err, true)
+	if n != 5000 || !bytes.Equal(got, content) {
+		t.Fatalf("bad content: %d bytes", n)
+	}
+	// The HEAD and the segments starting on the missing mirror move on
+	if atomic.LoadInt32(&ranges) != 5 || atomic.LoadInt32(&missing) != 4 {
+		t.Fatalf("expected 5 ranges and 4 refusals, got %d and %d", ranges, missing)
+	}
+}
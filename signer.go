@@ -0,0 +1,144 @@
+package retrigo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Signer signs an attempt right before it is sent, once the request points
+// at its final url. body is a fresh reader over the request body, empty when
+// there is none. Signatures are computed again for every attempt, so they
+// can cover the target and the time of the attempt.
+type Signer interface {
+	Sign(req *http.Request, body io.Reader) error
+}
+
+// SignerFunc is an adapter to use a function as a Signer
+type SignerFunc func(req *http.Request, body io.Reader) error
+
+// Sign calls f(req, body)
+func (f SignerFunc) Sign(req *http.Request, body io.Reader) error {
+	return f(req, body)
+}
+
+// Canonicalizer returns the string signed for req, covering signedHeaders,
+// lower case, and the hex encoded hash of the body
+type Canonicalizer func(req *http.Request, signedHeaders []string, bodyHash string) string
+
+// CanonicalRequest is the default Canonicalizer. It joins with newlines the
+// method, the escaped path, the query sorted by key, a "name:value" line for
+// each signed header and the body hash. The host header is the Host of the
+// request, or the host of its url.
+func CanonicalRequest(req *http.Request, signedHeaders []string, bodyHash string) string {
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, escapeQuery(k)+"="+escapeQuery(v))
+		}
+	}
+
+	lines := []string{req.Method, path, strings.Join(pairs, "&")}
+	for _, name := range signedHeaders {
+		value := strings.TrimSpace(req.Header.Get(name))
+		if name == "host" {
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		}
+		lines = append(lines, name+":"+value)
+	}
+	return strings.Join(append(lines, bodyHash), "\n")
+}
+
+// escapeQuery percent-encodes s, spaces included, as query components
+func escapeQuery(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// HMACSigner signs attempts with an HMAC over their canonical form, in the
+// fashion of AWS SigV4. It sets the Date header to the time of the attempt,
+// X-Content-SHA256 to the body hash, and the Authorization header to
+//
+//	HMAC-SHA256 KeyId=<KeyID>, SignedHeaders=host;date;x-content-sha256, Signature=<hex>
+//
+// Set Header to carry the signature in another header when the
+// Authorization header holds a TokenSource token.
+type HMACSigner struct {
+	KeyID string // Key identifier sent along the signature
+	Key   []byte // Secret key
+
+	Header        string           // Header carrying the signature, Authorization when empty
+	Hash          func() hash.Hash // Hash of the HMAC and of the body, sha256.New when nil
+	Algorithm     string           // Algorithm name in the signature header, HMAC-SHA256 when empty
+	SignedHeaders []string         // Headers covered, host, date and x-content-sha256 when nil
+	Canonicalize  Canonicalizer    // Canonical form of the request, CanonicalRequest when nil
+	Now           func() time.Time // Clock for the Date header, time.Now when nil
+}
+
+// Sign sets the Date, X-Content-SHA256 and signature headers of req
+func (s *HMACSigner) Sign(req *http.Request, body io.Reader) error {
+	newHash := s.Hash
+	if newHash == nil {
+		newHash = sha256.New
+	}
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	algorithm := s.Algorithm
+	if algorithm == "" {
+		algorithm = "HMAC-SHA256"
+	}
+	signed := s.SignedHeaders
+	if signed == nil {
+		signed = []string{"host", "date", "x-content-sha256"}
+	}
+	canonicalize := s.Canonicalize
+	if canonicalize == nil {
+		canonicalize = CanonicalRequest
+	}
+	header := s.Header
+	if header == "" {
+		header = "Authorization"
+	}
+
+	h := newHash()
+	if _, err := io.Copy(h, body); err != nil {
+		return err
+	}
+	bodyHash := hex.EncodeToString(h.Sum(nil))
+	req.Header.Set("Date", now().UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Content-SHA256", bodyHash)
+
+	names := make([]string, len(signed))
+	for i, name := range signed {
+		names[i] = strings.ToLower(name)
+	}
+	mac := hmac.New(newHash, s.Key)
+	io.WriteString(mac, canonicalize(req, names, bodyHash))
+	req.Header.Set(header, algorithm+" KeyId="+s.KeyID+
+		", SignedHeaders="+strings.Join(names, ";")+
+		", Signature="+hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
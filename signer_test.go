@@ -0,0 +1,161 @@
+package retrigo
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCanonicalRequest(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://example.com/a%20b?z=1&a=2&a=1&q=x+y", nil)
+	checkErr(t, err, true)
+	req.Header.Set("Date", "Mon, 02 Jan 2006 15:04:05 GMT")
+
+	got := CanonicalRequest(req, []string{"host", "date"}, "abc")
+	want := "GET\n/a%20b\na=1&a=2&q=x%20y&z=1\nhost:example.com\ndate:Mon, 02 Jan 2006 15:04:05 GMT\nabc"
+	if got != want {
+		t.Fatalf("got %q, expected %q", got, want)
+	}
+}
+
+func TestClient_Signer(t *testing.T) {
+	clock := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	signer := &HMACSigner{
+		KeyID: "key1",
+		Key:   []byte("secret"),
+		Now: func() time.Time {
+			clock = clock.Add(time.Second)
+			return clock
+		},
+	}
+
+	var mu sync.Mutex
+	var auths []string
+	verify := func(w http.ResponseWriter, r *http.Request) bool {
+		body, _ := io.ReadAll(r.Body)
+		date, err := http.ParseTime(r.Header.Get("Date"))
+		if err != nil {
+			t.Errorf("bad Date: %v", err)
+			return false
+		}
+		check := &HMACSigner{KeyID: "key1", Key: []byte("secret"), Now: func() time.Time { return date }}
+		expected := r.Clone(r.Context())
+		checkErr(t, check.Sign(expected, bytes.NewReader(body)), true)
+		if got := r.Header.Get("Authorization"); got != expected.Header.Get("Authorization") {
+			t.Errorf("bad signature: %s, expected %s", got, expected.Header.Get("Authorization"))
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		auths = append(auths, r.Header.Get("Authorization"))
+		return true
+	}
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verify(w, r)
+		w.WriteHeader(503)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verify(w, r)
+	}))
+	defer good.Close()
+
+	client := NewClient()
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.Signer = signer
+	req, err := NewRequest("POST", bad.URL+"/x?b=1 "+good.URL+"/x?b=1", strings.NewReader("payload"))
+	checkErr(t, err, true)
+	resp, err := client.Do(req)
+	checkErr(t, err, true)
+	resp.Body.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(auths) != 2 || auths[0] == auths[1] || !strings.HasPrefix(auths[0], "HMAC-SHA256 KeyId=key1, SignedHeaders=host;date;x-content-sha256, Signature=") {
+		t.Fatalf("bad signatures: %q", auths)
+	}
+	if req.Header.Get("Authorization") != "" || req.Header.Get("Date") != "" {
+		t.Fatal("signature leaked into the caller's headers")
+	}
+}
+
+func TestClient_Signer_func(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Body") != "hello" || r.Header.Get("X-Url") != "http://"+r.Host+"/p" {
+			t.Errorf("bad signer headers: %v", r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "hello" {
+			t.Errorf("body consumed by the signer: %q", body)
+		}
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.Signer = SignerFunc(func(req *http.Request, body io.Reader) error {
+		b, err := io.ReadAll(body)
+		req.Header.Set("X-Body", string(b))
+		req.Header.Set("X-Url", req.URL.String())
+		return err
+	})
+	resp, err := client.Post(ts.URL+"/p", "text/plain", []byte("hello"))
+	checkErr(t, err, true)
+	resp.Body.Close()
+}
+
+func TestClient_Signer_errors(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer ts.Close()
+
+	var signs int32
+	fail := errors.New("no key")
+	client := NewClient()
+	client.Signer = SignerFunc(func(req *http.Request, body io.Reader) error {
+		atomic.AddInt32(&signs, 1)
+		return fail
+	})
+	req, err := NewRequest("GET", ts.URL, nil)
+	checkErr(t, err, true)
+	if _, err := client.Do(req); !errors.Is(err, fail) {
+		t.Fatalf("expected the signer error, got %v", err)
+	}
+	if s, c := atomic.LoadInt32(&signs), atomic.LoadInt32(&calls); s != 1 || c != 0 {
+		t.Fatalf("signer error retried: %d signs, %d calls", s, c)
+	}
+}
+
+func TestClient_Signer_TokenSource(t *testing.T) {
+	var issued int32
+	tokens := tokenServer(t, &issued)
+	defer tokens.Close()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t1" || !strings.HasPrefix(r.Header.Get("X-Signature"), "HMAC-SHA256 KeyId=key1") {
+			t.Errorf("bad credentials: %v", r.Header)
+		}
+	}))
+	defer api.Close()
+
+	// The token request keeps its client credentials
+	client := NewClient()
+	client.Signer = &HMACSigner{KeyID: "key1", Key: []byte("secret"), Header: "X-Signature"}
+	client.TokenSource = &ClientCredentials{
+		Client:       client,
+		TokenURL:     tokens.URL,
+		ClientID:     "app",
+		ClientSecret: "s3cret",
+		Scopes:       []string{"read", "write"},
+	}
+	resp, err := client.Get(api.URL)
+	checkErr(t, err, true)
+	resp.Body.Close()
+}
@@ -0,0 +1,199 @@
+package retrigo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// DefaultFileCheckInterval is the default minimum time between two checks
+	// of a FileTargets file for changes
+	DefaultFileCheckInterval = 5 * time.Second
+)
+
+// TargetSource supplies a target list which may change at runtime.
+//
+// Implementations must hand out snapshots: a returned slice, and the targets
+// in it, must never be modified afterwards, changes are published by
+// returning a new slice. This way an in-flight Do keeps a consistent list.
+type TargetSource interface {
+	Targets(ctx context.Context) ([]*Target, error)
+}
+
+// StaticTargets is a TargetSource holding a list which only changes when Set
+// is called.
+type StaticTargets struct {
+	targets atomic.Value // []*Target
+}
+
+// NewStaticTargets creates a StaticTargets serving targets
+func NewStaticTargets(targets ...*Target) *StaticTargets {
+	s := &StaticTargets{}
+	s.Set(targets)
+	return s
+}
+
+// Targets returns the current target list
+func (s *StaticTargets) Targets(ctx context.Context) ([]*Target, error) {
+	targets, _ := s.targets.Load().([]*Target)
+	if len(targets) == 0 {
+		return nil, ErrNoTargets
+	}
+	return targets, nil
+}
+
+// Set replaces the target list, targets is copied so the caller may reuse it
+func (s *StaticTargets) Set(targets []*Target) {
+	s.targets.Store(append([]*Target(nil), targets...))
+}
+
+// FileTargets is a TargetSource which reads the target list from a file and
+// reloads it when the file changes. The file is checked at most once every
+// Interval, when Targets is called.
+//
+// The file either holds one url per line, blank lines and lines starting with
+// # being ignored, or a JSON array whose items are urls or objects such as
+//
+//	{"url": "http://a:8080", "weight": 2, "priority": 1, "host": "api",
+//	 "header": {"X-Zone": ["a"]}, "labels": {"zone": "a"}}
+//
+// A file which can't be read or fails ValidateTargets is ignored and the last
+// good list keeps being served.
+type FileTargets struct {
+	Path     string        // Path of the target file
+	Interval time.Duration // Minimum time between checks for changes
+
+	mu      sync.Mutex
+	targets []*Target
+	checked time.Time
+	modTime time.Time
+	size    int64
+}
+
+// NewFileTargets creates a new FileTargets with default settings
+func NewFileTargets(path string) *FileTargets {
+	return &FileTargets{
+		Path:     path,
+		Interval: DefaultFileCheckInterval,
+	}
+}
+
+// Targets returns the current target list, reloading the file first if it
+// changed. An error is only returned when the file was never loaded.
+func (f *FileTargets) Targets(ctx context.Context) ([]*Target, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.targets != nil && time.Since(f.checked) < f.Interval {
+		return f.targets, nil
+	}
+
+	err := f.reload()
+	if err != nil && f.targets == nil {
+		return nil, err
+	}
+	return f.targets, nil
+}
+
+// Reload reads the file right away if it changed since the last load
+func (f *FileTargets) Reload() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reload()
+}
+
+func (f *FileTargets) reload() error {
+	f.checked = time.Now()
+
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return err
+	}
+	if f.targets != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return err
+	}
+	targets, err := parseTargetFile(data)
+	if err != nil {
+		return err
+	}
+	if err := ValidateTargets(targets); err != nil {
+		return err
+	}
+
+	f.targets = targets
+	f.modTime, f.size = info.ModTime(), info.Size()
+	return nil
+}
+
+// fileTarget is the JSON form of a Target
+type fileTarget struct {
+	URL      string            `json:"url"`
+	Weight   int               `json:"weight"`
+	Priority int               `json:"priority"`
+	Host     string            `json:"host"`
+	Header   http.Header       `json:"header"`
+	Labels   map[string]string `json:"labels"`
+}
+
+func (ft *fileTarget) UnmarshalJSON(data []byte) error {
+	// Plain strings are accepted as a shorthand for {"url": "..."}
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &ft.URL)
+	}
+	type plain fileTarget
+	return json.Unmarshal(data, (*plain)(ft))
+}
+
+func parseTargetFile(data []byte) ([]*Target, error) {
+	data = bytes.TrimSpace(data)
+
+	var entries []fileTarget
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, err
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			entries = append(entries, fileTarget{URL: line})
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	targets := make([]*Target, len(entries))
+	for i, e := range entries {
+		u, err := url.Parse(e.URL)
+		if err != nil {
+			return nil, &TargetError{Index: i, Target: e.URL, Err: err}
+		}
+		targets[i] = &Target{
+			URL:      u,
+			Weight:   e.Weight,
+			Priority: e.Priority,
+			Host:     e.Host,
+			Header:   e.Header,
+			Labels:   e.Labels,
+		}
+	}
+	return targets, nil
+}
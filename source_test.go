@@ -0,0 +1,125 @@
+package retrigo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	_ TargetSource = (*StaticTargets)(nil)
+	_ TargetSource = (*FileTargets)(nil)
+	_ TargetSource = (*SRVTargets)(nil)
+)
+
+func TestStaticTargets(t *testing.T) {
+	src := NewStaticTargets()
+	_, err := src.Targets(context.Background())
+	checkErr(t, err, false)
+
+	list := URLTargets(mustParse(t, "http://a"), mustParse(t, "http://b"))
+	src.Set(list)
+	snapshot, err := src.Targets(context.Background())
+	checkErr(t, err, true)
+
+	// Changing the source or the caller's slice doesn't affect a snapshot
+	list[0] = &Target{URL: mustParse(t, "http://c")}
+	src.Set(URLTargets(mustParse(t, "http://d")))
+	if len(snapshot) != 2 || snapshot[0].String() != "http://a" {
+		t.Fatalf("snapshot changed: %v", snapshot)
+	}
+	targets, err := src.Targets(context.Background())
+	checkErr(t, err, true)
+	if len(targets) != 1 || targets[0].String() != "http://d" {
+		t.Fatalf("bad targets: %v", targets)
+	}
+}
+
+func writeFile(t *testing.T, path, data string, mtime time.Time) {
+	checkErr(t, os.WriteFile(path, []byte(data), 0o600), true)
+	checkErr(t, os.Chtimes(path, mtime, mtime), true)
+}
+
+func TestFileTargets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets")
+	src := NewFileTargets(path)
+	src.Interval = 0
+
+	// Fails while the file doesn't exist
+	_, err := src.Targets(context.Background())
+	checkErr(t, err, false)
+
+	now := time.Now()
+	writeFile(t, path, "# backends\nhttp://a:8080\n\n  http://b:8080  \n", now)
+	targets, err := src.Targets(context.Background())
+	checkErr(t, err, true)
+	if len(targets) != 2 || targets[1].String() != "http://b:8080" {
+		t.Fatalf("bad targets: %v", targets)
+	}
+
+	// Reloads JSON files when they change
+	writeFile(t, path, `["http://c:8080", {"url": "http://d:8080", "weight": 3, "priority": 1,
+		"host": "api", "header": {"X-Zone": ["b"]}, "labels": {"zone": "b"}}]`, now.Add(time.Second))
+	targets, err = src.Targets(context.Background())
+	checkErr(t, err, true)
+	if len(targets) != 2 || targets[0].String() != "http://c:8080" {
+		t.Fatalf("bad targets: %v", targets)
+	}
+	d := targets[1]
+	if d.Weight != 3 || d.Priority != 1 || d.Host != "api" || d.Header.Get("X-Zone") != "b" || d.Labels["zone"] != "b" {
+		t.Fatalf("bad target metadata: %#v", d)
+	}
+
+	// Keeps the last good list on invalid content
+	writeFile(t, path, "not-absolute\n", now.Add(2*time.Second))
+	checkErr(t, src.Reload(), false)
+	targets, err = src.Targets(context.Background())
+	checkErr(t, err, true)
+	if len(targets) != 2 {
+		t.Fatalf("expected last good targets, got: %v", targets)
+	}
+
+	// Checks for changes at most once per Interval
+	src.Interval = time.Hour
+	writeFile(t, path, "http://e:8080\n", now.Add(3*time.Second))
+	targets, err = src.Targets(context.Background())
+	checkErr(t, err, true)
+	if len(targets) != 2 {
+		t.Fatalf("reloaded before Interval: %v", targets)
+	}
+}
+
+func TestClient_Do_TargetSource(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.RetryMax = 2
+	client.Targets = NewStaticTargets(URLTargets(mustParse(t, "http://127.0.0.1:1"), mustParse(t, ts.URL))...)
+
+	// Requests without an absolute url use the client targets
+	req, err := NewRequest("GET", "", nil)
+	checkErr(t, err, true)
+	resp, err := client.Do(req)
+	checkErr(t, err, true)
+	resp.Body.Close()
+
+	// Requests with their own targets ignore them
+	req, err = NewRequest("GET", "http://127.0.0.1:1", nil)
+	checkErr(t, err, true)
+	_, err = client.Do(req)
+	checkErr(t, err, false)
+
+	// A source without targets fails the request
+	client.Targets = NewStaticTargets()
+	req, err = NewRequest("GET", "", nil)
+	checkErr(t, err, true)
+	_, err = client.Do(req)
+	checkErr(t, err, false)
+}
@@ -0,0 +1,127 @@
+package retrigo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultSRVTTL is the default time a resolved SRV target list is kept
+	// before it is looked up again
+	DefaultSRVTTL = 30 * time.Second
+)
+
+// SRVResolver looks up DNS SRV records, it is satisfied by *net.Resolver
+type SRVResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// SRVTargets is a TargetSource which discovers targets through DNS SRV
+// records. The resolved records are kept for TTL, after which the next call
+// to Targets looks them up again. When a lookup fails the last good target
+// list keeps being served.
+//
+// Each record becomes a Target with the record priority and weight, so
+// PriorityScheduler can be used to honour them.
+type SRVTargets struct {
+	Service string // Service name, e.g. "http"
+	Proto   string // Protocol, e.g. "tcp"
+	Name    string // Domain name to look up
+
+	Scheme   string        // Scheme of the target urls, "http" when empty
+	Path     string        // Path of the target urls
+	TTL      time.Duration // How long the resolved targets are kept
+	Resolver SRVResolver   // Resolver used for lookups, net.DefaultResolver when nil
+
+	mu      sync.Mutex
+	targets []*Target
+	expires time.Time
+}
+
+// NewSRVTargets creates a new SRVTargets with default settings
+func NewSRVTargets(service, proto, name string) *SRVTargets {
+	return &SRVTargets{
+		Service:  service,
+		Proto:    proto,
+		Name:     name,
+		Scheme:   "http",
+		TTL:      DefaultSRVTTL,
+		Resolver: net.DefaultResolver,
+	}
+}
+
+// Targets returns the current target list, refreshing it first when the TTL
+// has expired. An error is only returned when no lookup succeeded yet.
+func (s *SRVTargets) Targets(ctx context.Context) ([]*Target, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.targets != nil && time.Now().Before(s.expires) {
+		return s.targets, nil
+	}
+
+	err := s.refresh(ctx)
+	if err != nil && s.targets == nil {
+		return nil, err
+	}
+	return s.targets, nil
+}
+
+// Refresh looks up the SRV records right away, regardless of the TTL
+func (s *SRVTargets) Refresh(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refresh(ctx)
+}
+
+func (s *SRVTargets) refresh(ctx context.Context) error {
+	resolver := s.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ttl := s.TTL
+	if ttl <= 0 {
+		ttl = DefaultSRVTTL
+	}
+	scheme := s.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	_, records, err := resolver.LookupSRV(ctx, s.Service, s.Proto, s.Name)
+	if err == nil && len(records) == 0 {
+		err = errors.New("no SRV records found for " + s.Name)
+	}
+	if err != nil {
+		// Keep serving the last good set, but don't hammer the resolver
+		// while it's failing.
+		if s.targets != nil {
+			s.expires = time.Now().Add(ttl)
+		}
+		return err
+	}
+
+	// Build a fresh slice, the previous one may still be in use by Do
+	targets := make([]*Target, len(records))
+	for i, rec := range records {
+		host := strings.TrimSuffix(rec.Target, ".")
+		targets[i] = &Target{
+			URL: &url.URL{
+				Scheme: scheme,
+				Host:   net.JoinHostPort(host, strconv.Itoa(int(rec.Port))),
+				Path:   s.Path,
+			},
+			Priority: int(rec.Priority),
+			Weight:   int(rec.Weight),
+		}
+	}
+	s.targets = targets
+	s.expires = time.Now().Add(ttl)
+	return nil
+}
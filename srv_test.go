@@ -0,0 +1,91 @@
+package retrigo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResolver is an in-memory SRVResolver
+type fakeResolver struct {
+	mu      sync.Mutex
+	records []*net.SRV
+	err     error
+	calls   int
+}
+
+func (f *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return "", nil, f.err
+	}
+	return "_" + service + "._" + proto + "." + name, f.records, nil
+}
+
+func (f *fakeResolver) set(records []*net.SRV, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.records, f.err = records, err
+}
+
+func TestSRVTargets(t *testing.T) {
+	resolver := &fakeResolver{}
+	src := NewSRVTargets("api", "tcp", "example.com")
+	src.Resolver = resolver
+	src.Path = "/v1"
+	src.TTL = 50 * time.Millisecond
+
+	// Fails when no lookup succeeded yet
+	resolver.set(nil, errors.New("lookup failed"))
+	_, err := src.Targets(context.Background())
+	checkErr(t, err, false)
+
+	resolver.set([]*net.SRV{
+		{Target: "a.example.com.", Port: 8080, Priority: 10, Weight: 5},
+		{Target: "b.example.com.", Port: 8081, Priority: 20, Weight: 1},
+	}, nil)
+	checkErr(t, src.Refresh(context.Background()), true)
+
+	targets, err := src.Targets(context.Background())
+	checkErr(t, err, true)
+	if len(targets) != 2 {
+		t.Fatalf("bad targets: %v", targets)
+	}
+	if v := targets[0].String(); v != "http://a.example.com:8080/v1" {
+		t.Fatalf("bad url: %s", v)
+	}
+	if targets[0].Priority != 10 || targets[0].Weight != 5 {
+		t.Fatalf("bad metadata: %#v", targets[0])
+	}
+
+	// Served from cache until the TTL expires
+	calls := resolver.calls
+	_, err = src.Targets(context.Background())
+	checkErr(t, err, true)
+	if resolver.calls != calls {
+		t.Fatalf("expected cached targets, resolver called %d times", resolver.calls)
+	}
+
+	// Keeps the last good set when a lookup fails
+	resolver.set(nil, errors.New("lookup failed"))
+	time.Sleep(60 * time.Millisecond)
+	targets, err = src.Targets(context.Background())
+	checkErr(t, err, true)
+	if len(targets) != 2 || resolver.calls != calls+1 {
+		t.Fatalf("expected last good targets: %v (%d calls)", targets, resolver.calls)
+	}
+
+	// Picks up changes after the TTL
+	resolver.set([]*net.SRV{{Target: "c.example.com.", Port: 80}}, nil)
+	time.Sleep(60 * time.Millisecond)
+	targets, err = src.Targets(context.Background())
+	checkErr(t, err, true)
+	if len(targets) != 1 || targets[0].String() != "http://c.example.com:80/v1" {
+		t.Fatalf("bad targets: %v", targets)
+	}
+}
@@ -0,0 +1,219 @@
+package retrigo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ErrNoTargets is returned when a request is built without any target
+	ErrNoTargets = errors.New("no targets")
+	// ErrNotAbsolute is returned when a target URL lacks a scheme or host
+	ErrNotAbsolute = errors.New("url is not absolute")
+	// ErrUnsupportedScheme is returned when a target URL scheme is not http or https
+	ErrUnsupportedScheme = errors.New("unsupported scheme")
+)
+
+// Target is a single destination a Request can be scheduled to. Besides the
+// URL it carries the metadata schedulers and Client.Do use when an attempt is
+// sent to it.
+type Target struct {
+	URL      *url.URL          // Absolute URL of the target
+	Weight   int               // Relative weight used by weighted schedulers, zero means 1
+	Priority int               // Priority tier, lower values are preferred by PriorityScheduler
+	Host     string            // Host header override, empty means URL.Host
+	Header   http.Header       // Headers added to every attempt sent to this target
+	Labels   map[string]string // Free form metadata such as a zone or tier
+}
+
+// String returns the target URL
+func (t *Target) String() string {
+	if t == nil || t.URL == nil {
+		return ""
+	}
+	return t.URL.String()
+}
+
+// key identifies the node behind the target, its scheme and host, whatever
+// the path and query of the requests sent to it
+func (t *Target) key() string {
+	if t == nil || t.URL == nil {
+		return ""
+	}
+	return t.URL.Scheme + "://" + t.URL.Host
+}
+
+// targetKey returns the scheme and host of the url s, the key of a Target.
+// s is returned as is when it isn't an absolute url.
+func targetKey(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return s
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// TargetError describes which entry of a target list failed validation
+type TargetError struct {
+	Index  int    // Position of the target on the list
+	Target string // The offending target URL
+	Err    error  // The reason it was rejected
+}
+
+func (e *TargetError) Error() string {
+	return fmt.Sprintf("target %d (%q): %v", e.Index, e.Target, e.Err)
+}
+
+// Unwrap returns the underlying validation error
+func (e *TargetError) Unwrap() error {
+	return e.Err
+}
+
+// URLTargets wraps a list of URLs as targets with no extra metadata
+func URLTargets(urls ...*url.URL) []*Target {
+	targets := make([]*Target, len(urls))
+	for i, u := range urls {
+		targets[i] = &Target{URL: u}
+	}
+	return targets
+}
+
+// ValidateTargets checks that targets is a usable target list: it must not be
+// empty and every entry must be an absolute http or https URL.
+func ValidateTargets(targets []*Target) error {
+	if len(targets) == 0 {
+		return ErrNoTargets
+	}
+	for i, t := range targets {
+		if t == nil || t.URL == nil {
+			return &TargetError{Index: i, Err: errors.New("missing url")}
+		}
+		if !t.URL.IsAbs() || t.URL.Host == "" {
+			return &TargetError{Index: i, Target: t.URL.String(), Err: ErrNotAbsolute}
+		}
+		if t.URL.Scheme != "http" && t.URL.Scheme != "https" {
+			return &TargetError{Index: i, Target: t.URL.String(), Err: ErrUnsupportedScheme}
+		}
+		if t.Weight < 0 {
+			return &TargetError{Index: i, Target: t.URL.String(), Err: errors.New("negative weight")}
+		}
+	}
+	return nil
+}
+
+// parseTargets splits a space separated list of urls into targets, the string
+// form accepted by NewRequest and the convenience helpers.
+func parseTargets(durl string) ([]*Target, error) {
+	dest := strings.Split(durl, " ")
+	targets := make([]*Target, len(dest))
+	for i, d := range dest {
+		u, err := url.Parse(d)
+		if err != nil {
+			return nil, err
+		}
+		targets[i] = &Target{URL: u}
+	}
+	return targets, nil
+}
+
+// targetURLs returns the string form of targets, as handed to the Scheduler
+func targetURLs(targets []*Target) []string {
+	urls := make([]string, len(targets))
+	for i, t := range targets {
+		urls[i] = t.String()
+	}
+	return urls
+}
+
+// NewRequestWithTargets creates a wrapped request that will be scheduled over
+// targets. Unlike NewRequest every target is validated with ValidateTargets,
+// so URLs may contain any character and carry per target metadata.
+func NewRequestWithTargets(method string, targets []*Target, rawBody interface{}) (*Request, error) {
+	if err := ValidateTargets(targets); err != nil {
+		return nil, err
+	}
+	return newRequest(method, targets, rawBody)
+}
+
+func newRequest(method string, targets []*Target, rawBody interface{}) (*Request, error) {
+	bodyReader, contentLength, err := getBodyReaderAndContentLength(rawBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// We build the http request with the first target on the list
+	u := *targets[0].URL
+	httpReq, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.URL = &u
+	// Leave Host empty so each attempt uses the host of the target it is
+	// scheduled to, unless the caller or the target overrides it.
+	httpReq.Host = ""
+	httpReq.ContentLength = contentLength
+	return &Request{body: bodyReader, Request: httpReq, urls: targetURLs(targets), targets: targets}, nil
+}
+
+// lookupTarget returns the target whose URL is dest, if any
+func lookupTarget(targets []*Target, urls []string, dest string) *Target {
+	for i, u := range urls {
+		if u == dest && i < len(targets) {
+			return targets[i]
+		}
+	}
+	return nil
+}
+
+// joinURL resolves the relative url ref against base. Unlike
+// url.ResolveReference the base path is kept as a prefix, so "/x" against
+// "http://a/api" is "http://a/api/x", and the base query is merged with the
+// ref query, ref values taking precedence.
+func joinURL(base, ref *url.URL) *url.URL {
+	u := *base
+	u.Fragment, u.RawFragment = ref.Fragment, ref.RawFragment
+
+	if p := ref.EscapedPath(); p != "" {
+		if !strings.HasPrefix(p, "/") {
+			p = "/" + p
+		}
+		u.RawPath = strings.TrimSuffix(base.EscapedPath(), "/") + p
+		u.Path, _ = url.PathUnescape(u.RawPath)
+		if u.EscapedPath() != u.RawPath {
+			u.RawPath = ""
+		}
+	}
+
+	switch {
+	case base.RawQuery == "":
+		u.RawQuery = ref.RawQuery
+	case ref.RawQuery != "":
+		q := base.Query()
+		for k := range ref.Query() {
+			q.Del(k)
+		}
+		u.RawQuery = ref.RawQuery
+		if len(q) > 0 {
+			u.RawQuery = q.Encode() + "&" + ref.RawQuery
+		}
+	}
+	return &u
+}
+
+// relative reports whether none of the request targets is an absolute url
+func (r *Request) relative() bool {
+	for _, t := range r.targets {
+		if t.URL.Host != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Targets returns the list of targets the request can be scheduled to
+func (r *Request) Targets() []*Target {
+	return r.targets
+}
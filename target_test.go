@@ -0,0 +1,153 @@
+package retrigo
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	u, err := url.Parse(raw)
+	checkErr(t, err, true)
+	return u
+}
+
+func TestValidateTargets(t *testing.T) {
+	// Fails on an empty list
+	_, err := NewRequestWithTargets("GET", nil, nil)
+	if !errors.Is(err, ErrNoTargets) {
+		t.Fatalf("expected ErrNoTargets, got: %v", err)
+	}
+
+	cases := []struct {
+		targets []*Target
+		index   int
+		expect  error
+	}{
+		{URLTargets(mustParse(t, "http://foo"), mustParse(t, "/bar")), 1, ErrNotAbsolute},
+		{URLTargets(mustParse(t, "http://foo"), mustParse(t, "http:///bar")), 1, ErrNotAbsolute},
+		{URLTargets(mustParse(t, "ftp://foo")), 0, ErrUnsupportedScheme},
+		{[]*Target{{URL: mustParse(t, "http://foo")}, {URL: mustParse(t, "https://bar"), Weight: -1}}, 1, nil},
+		{[]*Target{{URL: mustParse(t, "http://foo")}, nil}, 1, nil},
+	}
+
+	for _, tc := range cases {
+		_, err := NewRequestWithTargets("GET", tc.targets, nil)
+		var terr *TargetError
+		if !errors.As(err, &terr) {
+			t.Fatalf("expected TargetError, got: %v", err)
+		}
+		if terr.Index != tc.index {
+			t.Fatalf("bad index: %d, expected %d (%v)", terr.Index, tc.index, err)
+		}
+		if tc.expect != nil && !errors.Is(err, tc.expect) {
+			t.Fatalf("expected %v, got: %v", tc.expect, err)
+		}
+	}
+
+	// Works with absolute http and https urls
+	_, err = NewRequestWithTargets("GET", URLTargets(mustParse(t, "http://foo"), mustParse(t, "https://bar/baz")), nil)
+	checkErr(t, err, true)
+}
+
+func TestClient_Do_targets(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/foo bar" {
+			t.Fatalf("bad path: %q", r.URL.Path)
+		}
+		if r.Host != "example.com" {
+			t.Fatalf("bad host: %s", r.Host)
+		}
+		if v := r.Header.Get("X-Target"); v != "good" {
+			t.Fatalf("bad target header: %s", v)
+		}
+		if v := r.Header.Get("X-Test"); v != "foo" {
+			t.Fatalf("bad request header: %s", v)
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	base := mustParse(t, ts.URL)
+	targets := []*Target{
+		{
+			URL:    &url.URL{Scheme: "http", Host: "127.0.0.1:1", Path: "/foo bar"},
+			Header: http.Header{"X-Target": []string{"bad"}},
+		},
+		{
+			URL:    &url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/foo bar"},
+			Host:   "example.com",
+			Header: http.Header{"X-Target": []string{"good"}},
+		},
+	}
+
+	req, err := NewRequestWithTargets("GET", targets, nil)
+	checkErr(t, err, true)
+	req.Header.Set("X-Test", "foo")
+
+	client := NewClient()
+	client.RetryMax = 2
+	resp, err := client.Do(req)
+	checkErr(t, err, true)
+	resp.Body.Close()
+
+	// Target overrides must not leak into the caller's request
+	if v := req.Header.Get("X-Target"); v != "" {
+		t.Fatalf("target header leaked: %s", v)
+	}
+	if req.Host != "" {
+		t.Fatalf("target host leaked: %s", req.Host)
+	}
+	if len(req.Targets()) != 2 {
+		t.Fatalf("bad targets: %v", req.Targets())
+	}
+}
+
+func TestJoinURL(t *testing.T) {
+	cases := []struct {
+		base   string
+		ref    string
+		expect string
+	}{
+		{"http://a", "", "http://a"},
+		{"http://a", "/x", "http://a/x"},
+		{"http://a/", "x", "http://a/x"},
+		{"http://a/api", "/x/y", "http://a/api/x/y"},
+		{"http://a/api/", "/x/", "http://a/api/x/"},
+		{"http://a/api", "?b=1", "http://a/api?b=1"},
+		{"http://a/api?k=v", "/x", "http://a/api/x?k=v"},
+		{"http://a/api?k=v", "/x?b=1", "http://a/api/x?k=v&b=1"},
+		{"http://a/api?k=v&b=0", "/x?b=1&b=2", "http://a/api/x?k=v&b=1&b=2"},
+		{"http://a/a%2Fb", "/c%20d", "http://a/a%2Fb/c%20d"},
+		{"http://a/api", "/x#frag", "http://a/api/x#frag"},
+	}
+
+	for _, tc := range cases {
+		if v := joinURL(mustParse(t, tc.base), mustParse(t, tc.ref)).String(); v != tc.expect {
+			t.Fatalf("bad: %s + %s -> %s, expected %s", tc.base, tc.ref, v, tc.expect)
+		}
+	}
+}
+
+func TestClient_Get_relative(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.RequestURI != "/api/foo/bar?k=v&b=1" {
+			t.Fatalf("bad uri: %s", r.RequestURI)
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.RetryMax = 2
+	client.Targets = NewStaticTargets(URLTargets(
+		mustParse(t, "http://127.0.0.1:1/api?k=v"),
+		mustParse(t, ts.URL+"/api?k=v"),
+	)...)
+
+	resp, err := client.Get("/foo/bar?b=1")
+	checkErr(t, err, true)
+	resp.Body.Close()
+}
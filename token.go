@@ -0,0 +1,141 @@
+package retrigo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultExpiryDelta is how long before its expiry a token is considered
+	// expired by ClientCredentials, so it isn't sent about to expire
+	DefaultExpiryDelta = 10 * time.Second
+)
+
+// Token is an access token
+type Token struct {
+	AccessToken string    // The token
+	TokenType   string    // Authorization scheme, Bearer when empty
+	Expiry      time.Time // When the token expires, zero if it doesn't
+}
+
+// Type returns the authorization scheme of the token
+func (t *Token) Type() string {
+	if t.TokenType == "" || strings.EqualFold(t.TokenType, "bearer") {
+		return "Bearer"
+	}
+	return t.TokenType
+}
+
+// TokenSource supplies the token of every attempt. rejected, when not nil,
+// is a token the server refused: a new one must be returned, unless the
+// source already replaced it, as when concurrent requests were refused the
+// same token.
+type TokenSource interface {
+	Token(ctx context.Context, rejected *Token) (*Token, error)
+}
+
+// TokenSourceFunc is an adapter to use a function as a TokenSource
+type TokenSourceFunc func(ctx context.Context, rejected *Token) (*Token, error)
+
+// Token calls f(ctx, rejected)
+func (f TokenSourceFunc) Token(ctx context.Context, rejected *Token) (*Token, error) {
+	return f(ctx, rejected)
+}
+
+// authorize sets the Authorization header of the attempt from the client
+// TokenSource, returning the token used. The headers are copied first, like
+// when signing.
+func (c *Client) authorize(req *Request, rejected *Token) (*Token, error) {
+	tok, err := c.TokenSource.Token(req.Context(), rejected)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: getting token: %w", req.Method, req.URL, err)
+	}
+	req.Header = req.Header.Clone()
+	req.Header.Set("Authorization", tok.Type()+" "+tok.AccessToken)
+	return tok, nil
+}
+
+// ClientCredentials is a TokenSource fetching OAuth2 tokens with the client
+// credentials grant, through a retrigo Client. The token is cached until it
+// expires or is rejected.
+type ClientCredentials struct {
+	Client       *Client    // Client fetching tokens, which may be the one using them, a default client when nil
+	TokenURL     string     // Token endpoint, a space separated list of urls like for NewRequest
+	ClientID     string     // Client identifier
+	ClientSecret string     // Client secret
+	Scopes       []string   // Scopes requested, none when empty
+	Params       url.Values // Additional parameters of the token request
+
+	// ExpiryDelta is how early the token is refreshed before its expiry,
+	// DefaultExpiryDelta when zero
+	ExpiryDelta time.Duration
+
+	mu    sync.Mutex
+	token *Token
+}
+
+// tokenResponse is the token endpoint response of RFC 6749
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Token returns the cached token, fetching a new one when there is none, it
+// is about to expire or it is the rejected one
+func (s *ClientCredentials) Token(ctx context.Context, rejected *Token) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delta := s.ExpiryDelta
+	if delta == 0 {
+		delta = DefaultExpiryDelta
+	}
+	// A token rejected along concurrent requests is only replaced once
+	if s.token != nil && (rejected == nil || rejected.AccessToken != s.token.AccessToken) &&
+		(s.token.Expiry.IsZero() || time.Now().Add(delta).Before(s.token.Expiry)) {
+		return s.token, nil
+	}
+
+	form := url.Values{}
+	for k, v := range s.Params {
+		form[k] = v
+	}
+	form.Set("grant_type", "client_credentials")
+	if len(s.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.Scopes, " "))
+	}
+
+	c := s.Client
+	if c == nil {
+		c = defaultClient
+	}
+	req, err := c.NewEncodedRequest(http.MethodPost, s.TokenURL, "application/x-www-form-urlencoded", form)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.ClientID), url.QueryEscape(s.ClientSecret))
+	req.anonymous = true
+	req = req.WithContext(ctx)
+
+	start := time.Now()
+	resp, err := DoAs[tokenResponse](c, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.AccessToken == "" {
+		return nil, fmt.Errorf("%s %s: no access token in response", req.Method, req.URL)
+	}
+	tok := &Token{AccessToken: resp.AccessToken, TokenType: resp.TokenType}
+	if resp.ExpiresIn > 0 {
+		tok.Expiry = start.Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+	s.token = tok
+	return tok, nil
+}
@@ -0,0 +1,192 @@
+package retrigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tokenServer issues tokens t1, t2... counting the token requests
+func tokenServer(t *testing.T, issued *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "app" || secret != "s3cret" {
+			t.Errorf("bad client credentials: %q %q", id, secret)
+		}
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "read write" {
+			t.Errorf("bad token request: %v", r.Form)
+		}
+		if r.Header.Get("Authorization") == "" {
+			t.Error("token request without credentials")
+		}
+		n := atomic.AddInt32(issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"t%d","token_type":"bearer","expires_in":3600}`, n)
+	}))
+}
+
+func TestClient_TokenSource(t *testing.T) {
+	var issued int32
+	tokens := tokenServer(t, &issued)
+	defer tokens.Close()
+
+	var calls int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		// t1 expired in flight
+		if r.Header.Get("Authorization") != "Bearer t2" {
+			w.WriteHeader(401)
+		}
+	}))
+	defer api.Close()
+
+	client := NewClient()
+	client.RetryMax = 0
+	client.TokenSource = &ClientCredentials{
+		Client:       client,
+		TokenURL:     tokens.URL,
+		ClientID:     "app",
+		ClientSecret: "s3cret",
+		Scopes:       []string{"read", "write"},
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(api.URL)
+		checkErr(t, err, true)
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Fatalf("request %d: bad status %d", i, resp.StatusCode)
+		}
+	}
+	if n := atomic.LoadInt32(&issued); n != 2 {
+		t.Fatalf("expected 2 tokens issued, got %d", n)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Fatalf("expected 3 api calls, got %d", n)
+	}
+}
+
+func TestClient_TokenSource_rejected(t *testing.T) {
+	var calls int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(401)
+	}))
+	defer api.Close()
+
+	var refreshes int32
+	client := NewClient()
+	client.TokenSource = TokenSourceFunc(func(ctx context.Context, rejected *Token) (*Token, error) {
+		if rejected != nil {
+			atomic.AddInt32(&refreshes, 1)
+		}
+		return &Token{AccessToken: "bad"}, nil
+	})
+	resp, err := client.Get(api.URL)
+	checkErr(t, err, true)
+	resp.Body.Close()
+	if resp.StatusCode != 401 || atomic.LoadInt32(&calls) != 2 || atomic.LoadInt32(&refreshes) != 1 {
+		t.Fatalf("expected a single refresh, got %d calls and %d refreshes", calls, refreshes)
+	}
+
+	fail := errors.New("token endpoint down")
+	client.TokenSource = TokenSourceFunc(func(ctx context.Context, rejected *Token) (*Token, error) {
+		return nil, fail
+	})
+	if _, err := client.Get(api.URL); !errors.Is(err, fail) {
+		t.Fatalf("expected the token error, got %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("request sent without a token: %d calls", n)
+	}
+}
+
+func TestClientCredentials_concurrent(t *testing.T) {
+	var issued int32
+	tokens := tokenServer(t, &issued)
+	defer tokens.Close()
+
+	// t1 is rejected, every request gets a 401 with it at the same time
+	var rejected sync.WaitGroup
+	rejected.Add(10)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer t1" {
+			rejected.Done()
+			rejected.Wait()
+			w.WriteHeader(401)
+		}
+	}))
+	defer api.Close()
+
+	client := NewClient()
+	client.RetryMax = 0
+	client.TokenSource = &ClientCredentials{
+		Client:       client,
+		TokenURL:     tokens.URL,
+		ClientID:     "app",
+		ClientSecret: "s3cret",
+		Scopes:       []string{"read", "write"},
+	}
+	// Fetch t1 up front
+	if _, err := client.TokenSource.Token(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(api.URL)
+			if err != nil {
+				t.Errorf("err: %v", err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != 200 {
+				t.Errorf("bad status %d", resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&issued); n != 2 {
+		t.Fatalf("expected a single refresh, got %d tokens issued", n)
+	}
+}
+
+func TestClient_TokenSource_budget(t *testing.T) {
+	var calls int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.WriteHeader(401)
+		case 2:
+			w.Write([]byte(`{"access_`))
+		default:
+			w.Write([]byte(`{"access_token":"ok"}`))
+		}
+	}))
+	defer api.Close()
+
+	// The retry with a new token leaves the RetryMax budget alone
+	client := NewClient()
+	client.RetryMax = 1
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.RetryDecodeErrors = true
+	client.TokenSource = TokenSourceFunc(func(ctx context.Context, rejected *Token) (*Token, error) {
+		return &Token{AccessToken: "t"}, nil
+	})
+	got, err := GetJSON[tokenResponse](client, api.URL)
+	checkErr(t, err, true)
+	if got.AccessToken != "ok" || atomic.LoadInt32(&calls) != 3 {
+		t.Fatalf("bad response %+v after %d calls", got, calls)
+	}
+}